@@ -0,0 +1,150 @@
+// Package accesscontrol decides whether medical personnel may see a patient's
+// data, from the access grants the patient has given them.
+package accesscontrol
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+	"github.com/ApolloMedTech/Middleware/models"
+)
+
+const (
+	defaultCacheTTL = 30 * time.Second
+	// maxCacheEntries bounds the cache; expired entries are swept beyond it.
+	maxCacheEntries = 10000
+)
+
+// decision is a cached answer, valid until the given time.
+type decision struct {
+	allowed bool
+	until   time.Time
+}
+
+// Service answers consent checks, caching each decision briefly so routes
+// under the same patient don't query the database every time.
+type Service struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]decision
+}
+
+// NewService creates a Service caching decisions for ttl; zero or negative
+// disables the cache.
+func NewService(ttl time.Duration) *Service {
+	return &Service{ttl: ttl, now: time.Now, cache: make(map[string]decision)}
+}
+
+var (
+	service     *Service
+	serviceOnce sync.Once
+)
+
+// DefaultService returns the Service configured by AccessControlConfig.
+func DefaultService() *Service {
+	serviceOnce.Do(func() {
+		ttl := config.GetConfig().AccessControl.CacheTTL
+		if ttl == 0 {
+			ttl = defaultCacheTTL
+		}
+		service = NewService(ttl)
+	})
+	return service
+}
+
+func cacheKey(personnelID int, numeroUtente string) string {
+	return strconv.Itoa(personnelID) + ":" + numeroUtente
+}
+
+// Allowed reports whether personnelID has an active grant from the patient
+// with numeroUtente: status active, already started and not yet expired.
+func (s *Service) Allowed(ctx context.Context, personnelID int, numeroUtente string) (bool, error) {
+	key := cacheKey(personnelID, numeroUtente)
+	now := s.now()
+
+	if s.ttl > 0 {
+		s.mu.Lock()
+		cached, ok := s.cache[key]
+		s.mu.Unlock()
+		if ok && now.Before(cached.until) {
+			return cached.allowed, nil
+		}
+	}
+
+	grant, err := s.activeGrant(ctx, personnelID, numeroUtente, now)
+	if err != nil {
+		return false, err
+	}
+
+	if s.ttl > 0 {
+		until := now.Add(s.ttl)
+		// Don't keep allowing past the end of the grant.
+		if grant != nil && grant.ExpiryDate.Valid && grant.ExpiryDate.Time.Before(until) {
+			until = grant.ExpiryDate.Time
+		}
+		s.store(key, decision{allowed: grant != nil, until: until}, now)
+	}
+
+	return grant != nil, nil
+}
+
+// activeGrant returns the grant lasting longest, or nil when there is none.
+func (s *Service) activeGrant(ctx context.Context, personnelID int, numeroUtente string, now time.Time) (*models.AccessControlRecord, error) {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read from the primary, so a revocation isn't missed on a lagging replica.
+	grant, err := dbmanager.QueryOne[models.AccessControlRecord](dbmanager.ReadYourWrites(ctx), dbManager, `SELECT access_id, numero_utente, personnel_id, access_status, start_date, expiry_date
+		FROM access_control
+		WHERE personnel_id = $1 AND numero_utente = $2 AND access_status = $3
+		AND start_date <= $4 AND (expiry_date IS NULL OR expiry_date > $4)
+		ORDER BY expiry_date DESC NULLS FIRST
+		LIMIT 1;`, personnelID, numeroUtente, models.AccessStatusActive, now)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &grant, nil
+}
+
+func (s *Service) store(key string, d decision, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.cache) >= maxCacheEntries {
+		for k, cached := range s.cache {
+			if !now.Before(cached.until) {
+				delete(s.cache, k)
+			}
+		}
+	}
+	s.cache[key] = d
+}
+
+// Invalidate forgets the cached decisions about a patient, to be called when
+// the patient's grants change so revocations apply at once on this instance.
+// Other instances catch up within the cache TTL.
+func (s *Service) Invalidate(numeroUtente string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	suffix := ":" + numeroUtente
+	for k := range s.cache {
+		if strings.HasSuffix(k, suffix) {
+			delete(s.cache, k)
+		}
+	}
+}
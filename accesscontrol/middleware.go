@@ -0,0 +1,47 @@
+package accesscontrol
+
+import (
+	"net/http"
+
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+	apperror "github.com/ApolloMedTech/Middleware/error"
+	"github.com/ApolloMedTech/Middleware/sessionmanager"
+	"github.com/ApolloMedTech/Middleware/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// RequirePatientConsent guards patient-scoped routes: the logged in user must
+// hold an active grant from the patient whose numero utente is in the route
+// parameter param, e.g. "numeroUtente" for /patients/:numeroUtente/records.
+// It answers 400 for an invalid numero utente, 401 when nobody is logged in
+// and 403 without a grant, rendered through the error page.
+func RequirePatientConsent(store *sessionmanager.MySessionStore, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		numeroUtente := c.Param(param)
+		if err := utils.ValidateNumeroUtente(numeroUtente); err != nil {
+			apperror.RenderError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user, err := store.LoadUser(c.Writer, c.Request)
+		if err != nil {
+			apperror.RenderError(c, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+
+		allowed, err := DefaultService().Allowed(dbmanager.RequestContext(c), user.ID, numeroUtente)
+		if err != nil {
+			logrus.Errorf("Error checking patient consent for user %d: %v", user.ID, err)
+			apperror.RenderError(c, http.StatusInternalServerError, "Could not check access to this patient")
+			return
+		}
+		if !allowed {
+			logrus.Warnf("Denied access to patient data to user %d without consent on %s", user.ID, c.Request.URL.Path)
+			apperror.RenderError(c, http.StatusForbidden, "The patient has not granted you access to this data")
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+)
+
+// Attempts is the failed login history of an account or IP address.
+type Attempts struct {
+	Failures    int          `db:"failures"`
+	LastFailure time.Time    `db:"last_failure"`
+	LockedUntil sql.NullTime `db:"locked_until"`
+}
+
+// AttemptStore keeps failed login attempts by key.
+type AttemptStore interface {
+	// Get returns the attempts for key, or zero Attempts when there are none.
+	Get(ctx context.Context, key string) (Attempts, error)
+	// RecordFailure adds a failure at now. Failures made before forgetBefore,
+	// or before an expired lock, are forgotten first.
+	RecordFailure(ctx context.Context, key string, now, forgetBefore time.Time) (Attempts, error)
+	// Lock locks key until the given time.
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Reset forgets every failure and lock of key.
+	Reset(ctx context.Context, key string) error
+}
+
+// PostgresAttemptStore keeps attempts in the login_attempts table, shared by
+// every instance of the application.
+type PostgresAttemptStore struct{}
+
+// Get implements AttemptStore.
+func (PostgresAttemptStore) Get(ctx context.Context, key string) (Attempts, error) {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return Attempts{}, err
+	}
+
+	// Read from the primary: a replica could miss the latest failures.
+	attempts, err := dbmanager.QueryOne[Attempts](dbmanager.ReadYourWrites(ctx), dbManager,
+		"SELECT failures, last_failure, locked_until FROM login_attempts WHERE attempt_key = $1;", key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Attempts{}, nil
+	}
+	return attempts, err
+}
+
+// RecordFailure implements AttemptStore.
+func (PostgresAttemptStore) RecordFailure(ctx context.Context, key string, now, forgetBefore time.Time) (Attempts, error) {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return Attempts{}, err
+	}
+
+	return dbmanager.QueryOne[Attempts](ctx, dbManager, `INSERT INTO login_attempts (attempt_key, failures, last_failure)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (attempt_key) DO UPDATE SET
+			failures = CASE
+				WHEN login_attempts.last_failure < $3 OR login_attempts.locked_until <= $2 THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			locked_until = CASE WHEN login_attempts.locked_until <= $2 THEN NULL ELSE login_attempts.locked_until END,
+			last_failure = $2
+		RETURNING failures, last_failure, locked_until;`, key, now, forgetBefore)
+}
+
+// Lock implements AttemptStore.
+func (PostgresAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return err
+	}
+
+	_, err = dbManager.UpdateContext(ctx, "UPDATE login_attempts SET locked_until = $1 WHERE attempt_key = $2;", until, key)
+	return err
+}
+
+// Reset implements AttemptStore.
+func (PostgresAttemptStore) Reset(ctx context.Context, key string) error {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return err
+	}
+
+	_, err = dbManager.DeleteContext(ctx, "DELETE FROM login_attempts WHERE attempt_key = $1;", key)
+	return err
+}
+
+// MemoryAttemptStore keeps attempts in memory. It only suits a single
+// instance, and everything is forgotten on restart.
+type MemoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+}
+
+// NewMemoryAttemptStore creates an empty MemoryAttemptStore.
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{attempts: make(map[string]Attempts)}
+}
+
+// Get implements AttemptStore.
+func (s *MemoryAttemptStore) Get(ctx context.Context, key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.attempts[key], nil
+}
+
+// RecordFailure implements AttemptStore.
+func (s *MemoryAttemptStore) RecordFailure(ctx context.Context, key string, now, forgetBefore time.Time) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := s.attempts[key]
+	lockExpired := attempts.LockedUntil.Valid && !attempts.LockedUntil.Time.After(now)
+	if attempts.LastFailure.Before(forgetBefore) || lockExpired {
+		attempts.Failures = 0
+	}
+	if lockExpired {
+		attempts.LockedUntil = sql.NullTime{}
+	}
+
+	attempts.Failures++
+	attempts.LastFailure = now
+	s.attempts[key] = attempts
+
+	if len(s.attempts) > memoryStoreSweepSize {
+		s.sweep(now, forgetBefore)
+	}
+
+	return attempts, nil
+}
+
+// memoryStoreSweepSize is the number of keys above which stale entries are dropped.
+const memoryStoreSweepSize = 10000
+
+// sweep drops the keys whose failures are all forgotten and which aren't locked.
+func (s *MemoryAttemptStore) sweep(now, forgetBefore time.Time) {
+	for key, attempts := range s.attempts {
+		locked := attempts.LockedUntil.Valid && attempts.LockedUntil.Time.After(now)
+		if !locked && attempts.LastFailure.Before(forgetBefore) {
+			delete(s.attempts, key)
+		}
+	}
+}
+
+// Lock implements AttemptStore.
+func (s *MemoryAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := s.attempts[key]
+	attempts.LockedUntil = sql.NullTime{Time: until, Valid: true}
+	s.attempts[key] = attempts
+
+	return nil
+}
+
+// Reset implements AttemptStore.
+func (s *MemoryAttemptStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
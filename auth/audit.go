@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Types of AuditEvent.
+const (
+	AuditAccountLocked   = "account_locked"
+	AuditAccountUnlocked = "account_unlocked"
+	AuditIPLocked        = "ip_locked"
+	AuditIPUnlocked      = "ip_unlocked"
+	AuditPasswordReset   = "password_reset"
+
+	AuditSecondFactorEnabled  = "second_factor_enabled"
+	AuditSecondFactorDisabled = "second_factor_disabled"
+	AuditSecondFactorLocked   = "second_factor_locked"
+	AuditRecoveryCodeUsed     = "recovery_code_used"
+
+	AuditWebAuthnRegistered = "webauthn_registered"
+	AuditWebAuthnCloned     = "webauthn_cloned"
+)
+
+// AuditEvent records a security relevant change to an account.
+type AuditEvent struct {
+	Type string
+	// Subject is the account email or IP address the event is about.
+	Subject string
+	// Actor is who caused the event, "system" for automatic actions.
+	Actor  string
+	IP     string
+	Time   time.Time
+	Detail string
+}
+
+// AuditSink receives audit events. Implementations must be safe for
+// concurrent use.
+type AuditSink interface {
+	Record(ctx context.Context, event AuditEvent)
+}
+
+// LogAuditSink writes audit events to the log.
+type LogAuditSink struct{}
+
+// Record implements AuditSink.
+func (LogAuditSink) Record(ctx context.Context, event AuditEvent) {
+	logrus.WithFields(logrus.Fields{
+		"audit":     event.Type,
+		"subject":   event.Subject,
+		"actor":     event.Actor,
+		"ip":        event.IP,
+		"eventTime": event.Time.Format(time.RFC3339),
+	}).Warn("Audit: ", event.Detail)
+}
+
+var (
+	auditSink   AuditSink = LogAuditSink{}
+	auditSinkMu sync.RWMutex
+)
+
+// SetAuditSink replaces where audit events go, the log by default.
+func SetAuditSink(sink AuditSink) {
+	auditSinkMu.Lock()
+	defer auditSinkMu.Unlock()
+
+	auditSink = sink
+}
+
+func audit(ctx context.Context, event AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	auditSinkMu.RLock()
+	sink := auditSink
+	auditSinkMu.RUnlock()
+
+	sink.Record(ctx, event)
+}
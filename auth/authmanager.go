@@ -1,46 +1,150 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"errors"
+	"sync"
 
 	"github.com/ApolloMedTech/Middleware/config"
 	"github.com/ApolloMedTech/Middleware/dbmanager"
-	"github.com/volatiletech/authboss/v3"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrInvalidCredentials is returned by Login both for an unknown email and for
+// a wrong password, so callers can't tell which accounts exist.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
 func Login(email, password string) (*config.ApolloUser, error) {
+	return LoginContext(context.Background(), email, password)
+}
 
-	dbManager, err := dbmanager.NewDBManager()
-	if err != nil {
+// LoginContext is like Login but stops querying the database once ctx is done.
+// Use dbmanager.RequestContext to tie it to a gin request.
+func LoginContext(ctx context.Context, email, password string) (*config.ApolloUser, error) {
+	return LoginFromIP(ctx, email, password, "")
+}
+
+// LoginFromIP is like LoginContext and also throttles failed attempts by the
+// client IP address, e.g. gin's c.ClientIP(). While the account or address
+// has to wait it returns a *ThrottledError without checking the password.
+func LoginFromIP(ctx context.Context, email, password, ip string) (*config.ApolloUser, error) {
+	t := DefaultThrottler()
+	if t == nil {
+		return login(ctx, email, password)
+	}
+
+	if err := t.Check(ctx, email, ip); err != nil {
 		return nil, err
 	}
 
-	defer dbManager.DB.Close()
+	user, err := login(ctx, email, password)
+	recordLoginResult(ctx, t, email, ip, err)
+	return user, err
+}
 
-	row := dbManager.DB.QueryRow("SELECT user_id, name, email, user_type FROM users WHERE email = $1 and password_hash = $2;", email, password)
+// login checks the credentials, without any throttling.
+func login(ctx context.Context, email, password string) (*config.ApolloUser, error) {
 
-	var user config.ApolloUser
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return nil, err
+	}
 
-	err = row.Scan(&user.ID, &user.Name, &user.Email, &user.UserType)
+	user, err := dbmanager.QueryOne[config.ApolloUser](ctx, dbManager, "SELECT user_id, name, email, user_type, password_hash FROM users WHERE email = $1;", email)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, authboss.ErrUserNotFound
+		if errors.Is(err, sql.ErrNoRows) {
+			// Spend the same time as for a wrong password so response times
+			// don't reveal whether the email exists.
+			CompareHashAndPassword(dummyHash(), password)
+			return nil, ErrInvalidCredentials
 		}
 		return nil, err
 	}
 
+	if err := CompareHashAndPassword(user.Password, password); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if needsRehash(user.Password) {
+		rehashPassword(ctx, dbManager, user.ID, user.Password, password)
+	}
+
+	// The hash isn't needed past this point and mustn't end up in the session.
+	user.Password = ""
 	return &user, nil
 }
 
+// rehashPassword stores a new hash of password made with the preferred hasher.
+// Failing to do so doesn't fail the login; it is retried on the next one.
+func rehashPassword(ctx context.Context, dbManager *dbmanager.DBManager, userID int, oldHash, password string) {
+	hash, err := GenerateHash(password)
+	if err != nil {
+		logrus.Errorf("Error rehashing password for user %d: %v", userID, err)
+		return
+	}
+
+	// Only replace the hash we verified, in case the password changed meanwhile.
+	_, err = dbManager.UpdateContext(ctx, "UPDATE users SET password_hash = $1 WHERE user_id = $2 AND password_hash = $3;", hash, userID, oldHash)
+	if err != nil {
+		logrus.Errorf("Error storing rehashed password for user %d: %v", userID, err)
+	}
+}
+
+// CompareHashAndPassword checks password against a stored hash made by any
+// known PasswordHasher.
 func CompareHashAndPassword(hash, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	hasher, err := hasherFor(hash)
+	if err != nil {
+		return err
+	}
+	return hasher.Verify(hash, password)
 }
 
+// GenerateHash hashes password with the preferred PasswordHasher.
 func GenerateHash(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
+	return PreferredHasher().Hash(password)
+}
+
+// bcryptCost returns the configured cost, or bcrypt.DefaultCost when it is
+// unset or out of range.
+func bcryptCost() int {
+	cost := config.GetConfig().Auth.BcryptCost
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return bcrypt.DefaultCost
 	}
-	return string(hashedPassword), nil
+	return cost
+}
+
+// needsRehash reports whether hash should be replaced, because it was made
+// with another algorithm than the preferred one or with other parameters.
+func needsRehash(hash string) bool {
+	preferred := PreferredHasher()
+	hasher, err := hasherFor(hash)
+	return err != nil || hasher.ID() != preferred.ID() || preferred.NeedsRehash(hash)
+}
+
+var (
+	dummy     string
+	dummyOnce sync.Once
+)
+
+// dummyHash returns a hash of a random password, compared against when the
+// email is unknown.
+func dummyHash() string {
+	dummyOnce.Do(func() {
+		secret := make([]byte, 16)
+		rand.Read(secret)
+
+		hash, err := GenerateHash(hex.EncodeToString(secret))
+		if err != nil {
+			logrus.Errorf("Error generating dummy password hash: %v", err)
+			return
+		}
+		dummy = hash
+	})
+	return dummy
 }
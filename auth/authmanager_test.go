@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// usePasswordConfig replaces the auth config for the test.
+func usePasswordConfig(t *testing.T, bcryptCost int, password config.PasswordConfig) {
+	cfg := &config.GetConfig().Auth
+	previousCost, previousPassword := cfg.BcryptCost, cfg.Password
+	cfg.BcryptCost, cfg.Password = bcryptCost, password
+	t.Cleanup(func() { cfg.BcryptCost, cfg.Password = previousCost, previousPassword })
+}
+
+func bcryptHash(t *testing.T, password string, cost int) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestLoginBcrypt(t *testing.T) {
+	usePasswordConfig(t, bcrypt.MinCost+1, config.PasswordConfig{Algorithm: AlgorithmBcrypt})
+
+	current := bcryptHash(t, "correct horse", bcrypt.MinCost+1)
+	outdated := bcryptHash(t, "correct horse", bcrypt.MinCost)
+
+	tests := []struct {
+		name     string
+		stored   string // empty for an unknown email
+		password string
+		wantErr  error
+		// wantRehash is set when the login must replace the stored hash.
+		wantRehash bool
+	}{
+		{name: "current cost", stored: current, password: "correct horse"},
+		{name: "outdated cost is rehashed", stored: outdated, password: "correct horse", wantRehash: true},
+		{name: "wrong password", stored: current, password: "battery staple", wantErr: ErrInvalidCredentials},
+		{name: "wrong password keeps the outdated hash", stored: outdated, password: "battery staple", wantErr: ErrInvalidCredentials},
+		{name: "unknown email", password: "correct horse", wantErr: ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{query: func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+				columns := []string{"user_id", "name", "email", "user_type", "password_hash"}
+				if tt.stored == "" {
+					return columns, nil
+				}
+				return columns, [][]driver.Value{{int64(7), "Ana", args[0], "patient", tt.stored}}
+			}}
+			useFakeDB(t, db)
+
+			user, err := login(context.Background(), "ana@example.com", tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("login error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				if user.ID != 7 || user.Email != "ana@example.com" {
+					t.Errorf("login returned %+v, want user 7", user)
+				}
+				if user.Password != "" {
+					t.Error("login returned the password hash")
+				}
+			}
+
+			execs := db.recorded()
+			if !tt.wantRehash {
+				if len(execs) != 0 {
+					t.Errorf("login ran %q, want no update", execs)
+				}
+				return
+			}
+
+			if len(execs) != 1 || !strings.HasPrefix(execs[0].query, "UPDATE users SET password_hash") {
+				t.Fatalf("login ran %q, want one password_hash update", execs)
+			}
+			rehashed := execs[0].args[0].(string)
+			if cost, err := bcrypt.Cost([]byte(rehashed)); err != nil || cost != bcrypt.MinCost+1 {
+				t.Errorf("rehashed cost = %d (%v), want %d", cost, err, bcrypt.MinCost+1)
+			}
+			if err := bcrypt.CompareHashAndPassword([]byte(rehashed), []byte(tt.password)); err != nil {
+				t.Errorf("rehashed password doesn't verify: %v", err)
+			}
+			if execs[0].args[2] != tt.stored {
+				t.Errorf("update replaces %v, want only the verified hash", execs[0].args[2])
+			}
+		})
+	}
+}
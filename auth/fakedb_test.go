@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+)
+
+// fakeDB is a database/sql driver for tests: query answers the statements
+// returning rows, and every other statement is recorded and reported as
+// affecting the rows exec returns, one without it.
+type fakeDB struct {
+	query func(query string, args []driver.Value) (columns []string, rows [][]driver.Value)
+	exec  func(query string, args []driver.Value) int64
+
+	mu    sync.Mutex
+	execs []fakeExec
+}
+
+// fakeExec is a statement run through fakeDB.
+type fakeExec struct {
+	query string
+	args  []driver.Value
+}
+
+// useFakeDB makes db the shared pool of dbmanager for the test.
+func useFakeDB(t *testing.T, db *fakeDB) {
+	dbmanager.SetDB(sql.OpenDB(db))
+	t.Cleanup(func() { dbmanager.ClosePool() })
+}
+
+func (db *fakeDB) recorded() []fakeExec {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]fakeExec(nil), db.execs...)
+}
+
+func (db *fakeDB) Open(string) (driver.Conn, error) {
+	return fakeConn{db}, nil
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	return fakeConn{db}, nil
+}
+
+func (db *fakeDB) Driver() driver.Driver {
+	return db
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c fakeConn) Commit() error {
+	return nil
+}
+
+func (c fakeConn) Rollback() error {
+	return nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.execs = append(c.db.execs, fakeExec{query: query, args: values(args)})
+	if c.db.exec != nil {
+		return driver.RowsAffected(c.db.exec(query, values(args))), nil
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	var columns []string
+	var rows [][]driver.Value
+	if c.db.query != nil {
+		columns, rows = c.db.query(query, values(args))
+	}
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	list := make([]driver.Value, len(args))
+	for i, arg := range args {
+		list[i] = arg.Value
+	}
+	return list
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
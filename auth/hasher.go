@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrPasswordMismatch is returned by PasswordHasher.Verify for a wrong password.
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// ErrInvalidHash is matched by the errors about stored hashes that are
+// malformed or whose parameters are out of range.
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// Identifiers of the built-in hashers, as used in PasswordConfig.Algorithm.
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// PasswordHasher hashes passwords into self-describing strings in the PHC
+// string format ($<id>$<params>$<salt>$<hash>), so the algorithm and
+// parameters of a stored hash can always be told apart.
+type PasswordHasher interface {
+	// ID is the algorithm identifier at the start of the hashes it produces.
+	ID() string
+	Hash(password string) (string, error)
+	// Verify returns ErrPasswordMismatch when password doesn't match encoded.
+	Verify(encoded, password string) error
+	// NeedsRehash reports whether encoded was made with other parameters than
+	// the hasher's current ones.
+	NeedsRehash(encoded string) bool
+}
+
+var (
+	customHashers   = make(map[string]PasswordHasher)
+	customHashersMu sync.RWMutex
+)
+
+// RegisterPasswordHasher makes an additional algorithm available, both to
+// verify existing hashes and to be chosen as the preferred one in the config.
+func RegisterPasswordHasher(hasher PasswordHasher) {
+	customHashersMu.Lock()
+	defer customHashersMu.Unlock()
+
+	customHashers[hasher.ID()] = hasher
+}
+
+// PreferredHasher returns the hasher used for new hashes, set by
+// PasswordConfig.Algorithm and argon2id by default.
+func PreferredHasher() PasswordHasher {
+	algorithm := config.GetConfig().Auth.Password.Algorithm
+	if algorithm == "" {
+		algorithm = AlgorithmArgon2id
+	}
+
+	hasher, err := hasherByID(algorithm)
+	if err != nil {
+		// An unknown algorithm in the config must not block logins.
+		return newArgon2idHasher()
+	}
+	return hasher
+}
+
+// hasherFor returns the hasher that produced encoded.
+func hasherFor(encoded string) (PasswordHasher, error) {
+	parts := strings.SplitN(encoded, "$", 3)
+	if len(parts) < 3 || parts[0] != "" {
+		return nil, fmt.Errorf("unrecognised password hash format")
+	}
+
+	switch parts[1] {
+	case "2a", "2b", "2y":
+		return hasherByID(AlgorithmBcrypt)
+	default:
+		return hasherByID(parts[1])
+	}
+}
+
+func hasherByID(id string) (PasswordHasher, error) {
+	switch id {
+	case AlgorithmBcrypt:
+		return BcryptHasher{Cost: bcryptCost()}, nil
+	case AlgorithmArgon2id:
+		return newArgon2idHasher(), nil
+	}
+
+	customHashersMu.RLock()
+	defer customHashersMu.RUnlock()
+
+	if hasher, ok := customHashers[id]; ok {
+		return hasher, nil
+	}
+	return nil, fmt.Errorf("unknown password hash algorithm %q", id)
+}
+
+// BcryptHasher hashes with bcrypt. Its modular crypt format ($2a$<cost>$...)
+// already carries the algorithm and cost.
+type BcryptHasher struct {
+	Cost int
+}
+
+// ID implements PasswordHasher.
+func (BcryptHasher) ID() string {
+	return AlgorithmBcrypt
+}
+
+// Hash implements PasswordHasher.
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify implements PasswordHasher.
+func (BcryptHasher) Verify(encoded, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+// NeedsRehash implements PasswordHasher.
+func (h BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher hashes with Argon2id into
+// $argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$<salt>$<hash>.
+type Argon2idHasher struct {
+	Memory      uint32 // in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Default Argon2id parameters, following the second recommendation of RFC 9106
+// with a lower parallelism.
+const (
+	defaultArgon2Memory      = 64 * 1024
+	defaultArgon2Iterations  = 3
+	defaultArgon2Parallelism = 2
+	argon2SaltLength         = 16
+	argon2KeyLength          = 32
+)
+
+// Limits on the parameters of stored Argon2id hashes, which are used as they
+// are to verify a password: a zero would panic and a huge memory would be
+// allocated for every login.
+const (
+	maxArgon2Memory     = 1024 * 1024 // 1 GiB
+	maxArgon2Iterations = 64
+	minArgon2KeyLength  = 16
+	maxArgon2KeyLength  = 128
+)
+
+func newArgon2idHasher() Argon2idHasher {
+	cfg := config.GetConfig().Auth.Password.Argon2
+	hasher := Argon2idHasher{
+		Memory:      cfg.MemoryKiB,
+		Iterations:  cfg.Iterations,
+		Parallelism: cfg.Parallelism,
+		SaltLength:  argon2SaltLength,
+		KeyLength:   argon2KeyLength,
+	}
+	if hasher.Memory == 0 {
+		hasher.Memory = defaultArgon2Memory
+	}
+	if hasher.Iterations == 0 {
+		hasher.Iterations = defaultArgon2Iterations
+	}
+	if hasher.Parallelism == 0 {
+		hasher.Parallelism = defaultArgon2Parallelism
+	}
+	return hasher
+}
+
+// ID implements PasswordHasher.
+func (Argon2idHasher) ID() string {
+	return AlgorithmArgon2id
+}
+
+// Hash implements PasswordHasher.
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("error generating salt: %v", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", AlgorithmArgon2id, argon2.Version,
+		h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify implements PasswordHasher.
+func (Argon2idHasher) Verify(encoded, password string) error {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(candidate, key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// NeedsRehash implements PasswordHasher.
+func (h Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := decodeArgon2id(encoded)
+	return err != nil ||
+		params.Memory != h.Memory ||
+		params.Iterations != h.Iterations ||
+		params.Parallelism != h.Parallelism ||
+		uint32(len(key)) != h.KeyLength
+}
+
+// decodeArgon2id parses an Argon2id PHC string.
+func decodeArgon2id(encoded string) (params Argon2idHasher, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return params, nil, nil, fmt.Errorf("%w: not an argon2id hash", ErrInvalidHash)
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w: unsupported argon2id version %q", ErrInvalidHash, parts[2])
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: invalid argon2id parameters %q: %v", ErrInvalidHash, parts[3], err)
+	}
+	if params.Parallelism == 0 || params.Iterations == 0 || params.Iterations > maxArgon2Iterations ||
+		params.Memory < 8*uint32(params.Parallelism) || params.Memory > maxArgon2Memory {
+		return params, nil, nil, fmt.Errorf("%w: argon2id parameters %q out of range", ErrInvalidHash, parts[3])
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: invalid argon2id salt: %v", ErrInvalidHash, err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: invalid argon2id key: %v", ErrInvalidHash, err)
+	}
+	if len(key) < minArgon2KeyLength || len(key) > maxArgon2KeyLength {
+		return params, nil, nil, fmt.Errorf("%w: argon2id key of %d bytes", ErrInvalidHash, len(key))
+	}
+
+	return params, salt, key, nil
+}
+
+// AuthbossHasher implements authboss.Hasher with CompareHashAndPassword and
+// GenerateHash, so passwords set through authboss's register and recover
+// modules use the same algorithms as Login. Set it as Config.Core.Hasher.
+type AuthbossHasher struct{}
+
+// CompareHashAndPassword implements authboss.Hasher.
+func (AuthbossHasher) CompareHashAndPassword(hash, password string) error {
+	return CompareHashAndPassword(hash, password)
+}
+
+// GenerateHash implements authboss.Hasher.
+func (AuthbossHasher) GenerateHash(password string) (string, error) {
+	return GenerateHash(password)
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap Argon2id parameters, so the tests stay fast.
+var testArgon2 = config.Argon2Config{MemoryKiB: 64, Iterations: 1, Parallelism: 1}
+
+// testKey is a well-formed, 32 byte Argon2id key for hashes with other faults.
+const testKey = "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+
+func TestPasswordHasherRoundTrip(t *testing.T) {
+	usePasswordConfig(t, bcrypt.MinCost, config.PasswordConfig{Argon2: testArgon2})
+
+	tests := []struct {
+		name       string
+		hasher     PasswordHasher
+		wantPrefix string
+	}{
+		{name: "bcrypt", hasher: BcryptHasher{Cost: bcrypt.MinCost}, wantPrefix: "$2a$04$"},
+		{name: "argon2id", hasher: newArgon2idHasher(), wantPrefix: "$argon2id$v=19$m=64,t=1,p=1$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if !strings.HasPrefix(encoded, tt.wantPrefix) {
+				t.Errorf("Hash = %q, want prefix %q", encoded, tt.wantPrefix)
+			}
+
+			again, err := tt.hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if again == encoded {
+				t.Error("two hashes of the same password are equal, want a random salt")
+			}
+
+			if err := tt.hasher.Verify(encoded, "correct horse"); err != nil {
+				t.Errorf("Verify with the right password: %v", err)
+			}
+			if err := tt.hasher.Verify(encoded, "battery staple"); !errors.Is(err, ErrPasswordMismatch) {
+				t.Errorf("Verify with a wrong password = %v, want ErrPasswordMismatch", err)
+			}
+
+			// The stored hash alone picks the algorithm.
+			if err := CompareHashAndPassword(encoded, "correct horse"); err != nil {
+				t.Errorf("CompareHashAndPassword: %v", err)
+			}
+			if tt.hasher.NeedsRehash(encoded) {
+				t.Error("NeedsRehash of a fresh hash = true, want false")
+			}
+		})
+	}
+}
+
+func TestCompareHashAndPasswordInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{name: "empty", hash: ""},
+		{name: "plain text", hash: "correct horse"},
+		{name: "unknown algorithm", hash: "$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA"},
+		{name: "argon2id missing parts", hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdA"},
+		{name: "argon2id other version", hash: "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA"},
+		{name: "argon2id bad parameters", hash: "$argon2id$v=19$m=x,t=1,p=1$c2FsdA$aGFzaA"},
+		{name: "argon2id bad salt", hash: "$argon2id$v=19$m=64,t=1,p=1$!!!$aGFzaA"},
+		{name: "argon2id short key", hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$aGFzaA"},
+		{name: "argon2id no iterations", hash: "$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHRzYWx0$" + testKey},
+		{name: "argon2id no parallelism", hash: "$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHRzYWx0$" + testKey},
+		{name: "argon2id too many iterations", hash: "$argon2id$v=19$m=64,t=4294967295,p=1$c2FsdHNhbHRzYWx0$" + testKey},
+		{name: "argon2id too much memory", hash: "$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdHNhbHRzYWx0$" + testKey},
+		{name: "argon2id too little memory", hash: "$argon2id$v=19$m=8,t=1,p=4$c2FsdHNhbHRzYWx0$" + testKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CompareHashAndPassword(tt.hash, "correct horse")
+			if err == nil || errors.Is(err, ErrPasswordMismatch) {
+				t.Errorf("CompareHashAndPassword = %v, want a format error", err)
+			}
+			if strings.HasPrefix(tt.hash, "$argon2id$") && !errors.Is(err, ErrInvalidHash) {
+				t.Errorf("CompareHashAndPassword = %v, want ErrInvalidHash", err)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argon2 := func(cfg config.Argon2Config) string {
+		usePasswordConfig(t, bcrypt.MinCost, config.PasswordConfig{Argon2: cfg})
+		hash, err := newArgon2idHasher().Hash("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	current := argon2(testArgon2)
+	moreMemory := argon2(config.Argon2Config{MemoryKiB: 128, Iterations: 1, Parallelism: 1})
+	moreIterations := argon2(config.Argon2Config{MemoryKiB: 64, Iterations: 2, Parallelism: 1})
+	moreThreads := argon2(config.Argon2Config{MemoryKiB: 64, Iterations: 1, Parallelism: 2})
+	bcryptCurrent := bcryptHash(t, "correct horse", bcrypt.MinCost)
+	bcryptHigher := bcryptHash(t, "correct horse", bcrypt.MinCost+1)
+
+	tests := []struct {
+		name      string
+		algorithm string
+		hash      string
+		want      bool
+	}{
+		{name: "argon2id current", algorithm: AlgorithmArgon2id, hash: current},
+		{name: "argon2id other memory", algorithm: AlgorithmArgon2id, hash: moreMemory, want: true},
+		{name: "argon2id other iterations", algorithm: AlgorithmArgon2id, hash: moreIterations, want: true},
+		{name: "argon2id other parallelism", algorithm: AlgorithmArgon2id, hash: moreThreads, want: true},
+		{name: "bcrypt to argon2id", algorithm: AlgorithmArgon2id, hash: bcryptCurrent, want: true},
+		{name: "default algorithm is argon2id", hash: bcryptCurrent, want: true},
+		{name: "bcrypt current", algorithm: AlgorithmBcrypt, hash: bcryptCurrent},
+		{name: "bcrypt other cost", algorithm: AlgorithmBcrypt, hash: bcryptHigher, want: true},
+		{name: "argon2id to bcrypt", algorithm: AlgorithmBcrypt, hash: current, want: true},
+		{name: "unknown format", algorithm: AlgorithmArgon2id, hash: "correct horse", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usePasswordConfig(t, bcrypt.MinCost, config.PasswordConfig{Algorithm: tt.algorithm, Argon2: testArgon2})
+
+			if got := needsRehash(tt.hash); got != tt.want {
+				t.Errorf("needsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
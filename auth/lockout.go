@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrTooManyAttempts is matched by the ThrottledError returned by Login while
+// an account or IP address has to wait.
+var ErrTooManyAttempts = errors.New("too many failed login attempts")
+
+// ThrottledError tells how long to wait before the next login attempt.
+type ThrottledError struct {
+	RetryAfter time.Duration
+	// Locked is set when the wait is a lockout rather than a backoff delay.
+	Locked bool
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%v, retry in %s", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+// Is makes errors.Is(err, ErrTooManyAttempts) hold.
+func (e *ThrottledError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// Defaults for the zero values of config.LockoutConfig.
+const (
+	defaultMaxFailures     = 5
+	defaultMaxIPFailures   = 50
+	defaultLockoutDuration = 15 * time.Minute
+	defaultBaseDelay       = time.Second
+	defaultMaxDelay        = 30 * time.Second
+)
+
+// Throttler slows down and locks out repeated failed logins, both per account
+// and per client IP address.
+type Throttler struct {
+	store AttemptStore
+	cfg   config.LockoutConfig
+	now   func() time.Time
+}
+
+// NewThrottler creates a Throttler keeping attempts in store, with the given
+// settings (zero values use the defaults).
+func NewThrottler(store AttemptStore, cfg config.LockoutConfig) *Throttler {
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = defaultMaxFailures
+	}
+	if cfg.MaxIPFailures <= 0 {
+		cfg.MaxIPFailures = defaultMaxIPFailures
+	}
+	if cfg.LockoutDuration <= 0 {
+		cfg.LockoutDuration = defaultLockoutDuration
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = defaultBaseDelay
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = defaultMaxDelay
+	}
+
+	return &Throttler{store: store, cfg: cfg, now: time.Now}
+}
+
+var (
+	throttler     *Throttler
+	throttlerOnce sync.Once
+)
+
+// DefaultThrottler returns the Throttler used by Login, built from the config.
+// It returns nil when throttling is disabled.
+func DefaultThrottler() *Throttler {
+	throttlerOnce.Do(func() {
+		if throttler != nil {
+			return
+		}
+
+		cfg := config.GetConfig().Auth.Lockout
+		if cfg.Disabled {
+			return
+		}
+
+		var store AttemptStore = PostgresAttemptStore{}
+		if cfg.Store == "memory" {
+			store = NewMemoryAttemptStore()
+		}
+		throttler = NewThrottler(store, cfg)
+	})
+	return throttler
+}
+
+// SetThrottler replaces the Throttler used by Login; nil disables throttling.
+func SetThrottler(t *Throttler) {
+	throttlerOnce.Do(func() {})
+	throttler = t
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns a ThrottledError when the account or IP address is locked or
+// still waiting out the delay after its last failure.
+func (t *Throttler) Check(ctx context.Context, email, ip string) error {
+	if err := t.check(ctx, accountKey(email), t.cfg.MaxFailures); err != nil {
+		return err
+	}
+	if ip != "" {
+		return t.check(ctx, ipKey(ip), t.cfg.MaxIPFailures)
+	}
+	return nil
+}
+
+func (t *Throttler) check(ctx context.Context, key string, maxFailures int) error {
+	attempts, err := t.store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	now := t.now()
+	if attempts.LockedUntil.Valid && attempts.LockedUntil.Time.After(now) {
+		return &ThrottledError{RetryAfter: attempts.LockedUntil.Time.Sub(now), Locked: true}
+	}
+
+	if attempts.Failures == 0 || attempts.LastFailure.Before(now.Add(-t.cfg.LockoutDuration)) || attempts.Failures >= maxFailures {
+		return nil
+	}
+	if next := attempts.LastFailure.Add(t.delay(attempts.Failures)); next.After(now) {
+		return &ThrottledError{RetryAfter: next.Sub(now)}
+	}
+	return nil
+}
+
+// delay is the wait after the given number of consecutive failures.
+func (t *Throttler) delay(failures int) time.Duration {
+	delay := t.cfg.BaseDelay
+	for i := 1; i < failures && delay < t.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.cfg.MaxDelay {
+		delay = t.cfg.MaxDelay
+	}
+	return delay
+}
+
+// Fail records a failed login for the account and IP address, locking either
+// once it reaches its maximum number of failures.
+func (t *Throttler) Fail(ctx context.Context, email, ip string) error {
+	if err := t.fail(ctx, accountKey(email), t.cfg.MaxFailures, AuditEvent{Type: AuditAccountLocked, Subject: email, IP: ip}); err != nil {
+		return err
+	}
+	if ip != "" {
+		return t.fail(ctx, ipKey(ip), t.cfg.MaxIPFailures, AuditEvent{Type: AuditIPLocked, Subject: ip, IP: ip})
+	}
+	return nil
+}
+
+func (t *Throttler) fail(ctx context.Context, key string, maxFailures int, event AuditEvent) error {
+	now := t.now()
+	attempts, err := t.store.RecordFailure(ctx, key, now, now.Add(-t.cfg.LockoutDuration))
+	if err != nil {
+		return err
+	}
+
+	if attempts.Failures < maxFailures || (attempts.LockedUntil.Valid && attempts.LockedUntil.Time.After(now)) {
+		return nil
+	}
+
+	until := now.Add(t.cfg.LockoutDuration)
+	if err := t.store.Lock(ctx, key, until); err != nil {
+		return err
+	}
+
+	event.Actor = "system"
+	event.Time = now
+	event.Detail = fmt.Sprintf("locked until %s after %d failed login attempts", until.Format(time.RFC3339), attempts.Failures)
+	audit(ctx, event)
+	return nil
+}
+
+// Succeed clears the failures of the account after a successful login. The
+// IP address keeps its history, since one valid account doesn't make an
+// address trustworthy.
+func (t *Throttler) Succeed(ctx context.Context, email string) error {
+	return t.store.Reset(ctx, accountKey(email))
+}
+
+// Unlock lifts the lock and failures of an account on behalf of an admin.
+func (t *Throttler) Unlock(ctx context.Context, email, admin string) error {
+	if err := t.store.Reset(ctx, accountKey(email)); err != nil {
+		return err
+	}
+
+	audit(ctx, AuditEvent{Type: AuditAccountUnlocked, Subject: email, Actor: admin, Detail: "unlocked by an administrator"})
+	return nil
+}
+
+// UnlockIP lifts the lock and failures of an IP address on behalf of an admin.
+func (t *Throttler) UnlockIP(ctx context.Context, ip, admin string) error {
+	if err := t.store.Reset(ctx, ipKey(ip)); err != nil {
+		return err
+	}
+
+	audit(ctx, AuditEvent{Type: AuditIPUnlocked, Subject: ip, Actor: admin, Detail: "unlocked by an administrator"})
+	return nil
+}
+
+// UnlockAccount unlocks an account with the default Throttler, see Throttler.Unlock.
+func UnlockAccount(ctx context.Context, email, admin string) error {
+	t := DefaultThrottler()
+	if t == nil {
+		return nil
+	}
+	return t.Unlock(ctx, email, admin)
+}
+
+// recordLoginResult updates the throttling state after a login attempt.
+// Failing to do so is logged but doesn't change the login outcome.
+func recordLoginResult(ctx context.Context, t *Throttler, email, ip string, loginErr error) {
+	var err error
+	switch {
+	case loginErr == nil:
+		err = t.Succeed(ctx, email)
+	case errors.Is(loginErr, ErrInvalidCredentials):
+		err = t.Fail(ctx, email, ip)
+	default:
+		return
+	}
+
+	if err != nil {
+		logrus.Errorf("Error recording login attempt: %v", err)
+	}
+}
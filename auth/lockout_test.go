@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/config"
+)
+
+func TestThrottlerMemoryStore(t *testing.T) {
+	cfg := config.LockoutConfig{
+		MaxFailures:     4,
+		MaxIPFailures:   6,
+		LockoutDuration: 10 * time.Minute,
+		BaseDelay:       time.Second,
+		MaxDelay:        3 * time.Second,
+	}
+
+	const (
+		fail    = "fail"
+		succeed = "succeed"
+		check   = "check"
+	)
+
+	// step advances the clock by wait, then runs action for email and ip.
+	// Checks expect to wait retryAfter, none when zero, locked or not.
+	type step struct {
+		wait       time.Duration
+		action     string
+		email, ip  string
+		retryAfter time.Duration
+		locked     bool
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name:  "no failures",
+			steps: []step{{action: check, email: "ana@example.com", ip: "10.0.0.1"}},
+		},
+		{
+			name: "delay after a failure",
+			steps: []step{
+				{action: fail, email: "ana@example.com", ip: "10.0.0.1"},
+				{action: check, email: "ana@example.com", ip: "10.0.0.1", retryAfter: time.Second},
+				{wait: 400 * time.Millisecond, action: check, email: "ana@example.com", ip: "10.0.0.1", retryAfter: 600 * time.Millisecond},
+				{wait: 600 * time.Millisecond, action: check, email: "ana@example.com", ip: "10.0.0.1"},
+			},
+		},
+		{
+			name: "delay doubles up to the maximum",
+			steps: []step{
+				{action: fail, email: "ana@example.com"},
+				{wait: time.Second, action: fail, email: "ana@example.com"},
+				{action: check, email: "ana@example.com", retryAfter: 2 * time.Second},
+				{wait: 2 * time.Second, action: fail, email: "ana@example.com"},
+				{action: check, email: "ana@example.com", retryAfter: 3 * time.Second},
+			},
+		},
+		{
+			name: "account locked at the maximum failures",
+			steps: []step{
+				{action: fail, email: "ana@example.com"},
+				{action: fail, email: "ana@example.com"},
+				{action: fail, email: "ana@example.com"},
+				{action: fail, email: "ana@example.com"},
+				{action: check, email: "ana@example.com", retryAfter: 10 * time.Minute, locked: true},
+				{wait: time.Minute, action: check, email: "ana@example.com", retryAfter: 9 * time.Minute, locked: true},
+				{action: check, email: "rui@example.com"},
+				{wait: 9 * time.Minute, action: check, email: "ana@example.com"},
+				// The expired lock doesn't count towards the next one.
+				{action: fail, email: "ana@example.com"},
+				{action: check, email: "ana@example.com", retryAfter: time.Second},
+			},
+		},
+		{
+			name: "emails are matched case-insensitively",
+			steps: []step{
+				{action: fail, email: "Ana@Example.com "},
+				{action: check, email: "ana@example.com", retryAfter: time.Second},
+			},
+		},
+		{
+			name: "success clears the account but not the address",
+			steps: []step{
+				{action: fail, email: "ana@example.com", ip: "10.0.0.1"},
+				{action: succeed, email: "ana@example.com", ip: "10.0.0.1"},
+				{action: check, email: "ana@example.com"},
+				{action: check, email: "ana@example.com", ip: "10.0.0.1", retryAfter: time.Second},
+			},
+		},
+		{
+			name: "old failures are forgotten",
+			steps: []step{
+				{action: fail, email: "ana@example.com"},
+				{action: fail, email: "ana@example.com"},
+				{action: fail, email: "ana@example.com"},
+				{wait: 11 * time.Minute, action: check, email: "ana@example.com"},
+				{action: fail, email: "ana@example.com"},
+				{action: check, email: "ana@example.com", retryAfter: time.Second},
+			},
+		},
+		{
+			name: "address locked across accounts",
+			steps: []step{
+				{action: fail, email: "a@example.com", ip: "10.0.0.1"},
+				{action: fail, email: "b@example.com", ip: "10.0.0.1"},
+				{action: fail, email: "c@example.com", ip: "10.0.0.1"},
+				{action: fail, email: "d@example.com", ip: "10.0.0.1"},
+				{action: fail, email: "e@example.com", ip: "10.0.0.1"},
+				{action: fail, email: "f@example.com", ip: "10.0.0.1"},
+				{action: check, email: "g@example.com", ip: "10.0.0.1", retryAfter: 10 * time.Minute, locked: true},
+				{action: check, email: "g@example.com", ip: "10.0.0.2"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+			throttler := NewThrottler(NewMemoryAttemptStore(), cfg)
+			throttler.now = func() time.Time { return now }
+
+			for i, s := range tt.steps {
+				now = now.Add(s.wait)
+
+				switch s.action {
+				case fail:
+					if err := throttler.Fail(ctx, s.email, s.ip); err != nil {
+						t.Fatalf("step %d: Fail: %v", i, err)
+					}
+				case succeed:
+					if err := throttler.Succeed(ctx, s.email); err != nil {
+						t.Fatalf("step %d: Succeed: %v", i, err)
+					}
+				case check:
+					err := throttler.Check(ctx, s.email, s.ip)
+					if s.retryAfter == 0 {
+						if err != nil {
+							t.Errorf("step %d: Check = %v, want no wait", i, err)
+						}
+						continue
+					}
+
+					var throttled *ThrottledError
+					if !errors.As(err, &throttled) || !errors.Is(err, ErrTooManyAttempts) {
+						t.Fatalf("step %d: Check = %v, want a ThrottledError", i, err)
+					}
+					if throttled.RetryAfter != s.retryAfter || throttled.Locked != s.locked {
+						t.Errorf("step %d: Check retry after %s, locked %v, want %s, %v", i, throttled.RetryAfter, throttled.Locked, s.retryAfter, s.locked)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+	"github.com/ApolloMedTech/Middleware/mailManager"
+	"github.com/ApolloMedTech/Middleware/templateManager"
+	"github.com/flosch/pongo2/v6"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultResetTokenTTL is how long a reset token is valid when
+// PasswordResetConfig.TokenTTL is not set.
+const defaultResetTokenTTL = time.Hour
+
+// MinPasswordLength is the shortest password ResetPassword accepts.
+const MinPasswordLength = 8
+
+const (
+	resetSelectorLength = 16
+	resetVerifierLength = 32
+)
+
+var (
+	// ErrInvalidResetToken is returned for unknown, malformed, used and
+	// expired reset tokens alike.
+	ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+	// ErrPasswordTooShort is returned for passwords under MinPasswordLength.
+	ErrPasswordTooShort = fmt.Errorf("password must be at least %d characters long", MinPasswordLength)
+)
+
+// A reset token is "<selector>.<verifier>". The selector finds the user
+// through users.recover_selector, and only a SHA-256 hash of the verifier is
+// stored in users.recover_verifier, so a leaked database can't be used to
+// reset passwords. Issuing a token replaces the previous one of the user and
+// using it clears it. These are the columns authboss's recover module uses,
+// so mount one or the other.
+
+// IssueResetToken creates a reset token for the user with email. It returns
+// sql.ErrNoRows when there is no such user.
+func IssueResetToken(ctx context.Context, email string) (string, error) {
+	selector, err := randomToken(resetSelectorLength)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomToken(resetVerifierLength)
+	if err != nil {
+		return "", err
+	}
+
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return "", err
+	}
+
+	rows, err := dbManager.UpdateContext(ctx, "UPDATE users SET recover_selector = $1, recover_verifier = $2, recover_expiry = $3 WHERE email = $4;",
+		selector, hashVerifier(verifier), time.Now().Add(resetTokenTTL()), email)
+	if err != nil {
+		return "", err
+	}
+	if rows == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	return selector + "." + verifier, nil
+}
+
+// SendPasswordReset emails a reset link to email, pointing at resetURL with
+// the token in the "token" query parameter. Unknown emails are silently
+// ignored, so the response doesn't reveal which accounts exist. It takes
+// longer for known ones though, so send it in the background of a request.
+func SendPasswordReset(ctx context.Context, email, resetURL string) error {
+	token, err := IssueResetToken(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logrus.Infof("Password reset requested for an unknown email")
+			return nil
+		}
+		return err
+	}
+
+	separator := "?"
+	if strings.Contains(resetURL, "?") {
+		separator = "&"
+	}
+	data := pongo2.Context{
+		"resetURL":   resetURL + separator + "token=" + token,
+		"validHours": resetTokenTTL().Hours(),
+	}
+
+	templates := config.GetConfig().Templates.Path
+	text, err := templateManager.RenderToString(templates+"/emails/password_reset.txt", data)
+	if err != nil {
+		return err
+	}
+	html, err := templateManager.RenderToString(templates+"/emails/password_reset.html", data)
+	if err != nil {
+		return err
+	}
+
+	return mailManager.Send(ctx, mailManager.Message{
+		To:      []string{email},
+		Subject: "Password reset",
+		Text:    text,
+		HTML:    html,
+	})
+}
+
+// ValidateResetToken checks token without using it, e.g. before showing the
+// form to choose a new password.
+func ValidateResetToken(ctx context.Context, token string) error {
+	selector, verifier, ok := splitResetToken(token)
+	if !ok {
+		return ErrInvalidResetToken
+	}
+
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return err
+	}
+
+	user, err := dbmanager.QueryOne[resetTokenRow](dbmanager.ReadYourWrites(ctx), dbManager,
+		"SELECT user_id, email, recover_verifier, recover_expiry FROM users WHERE recover_selector = $1;", selector)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	return user.check(verifier)
+}
+
+// ResetPassword sets a new password for the user token was issued to and
+// uses the token up. The user's sessions are ended and any lockout lifted.
+func ResetPassword(ctx context.Context, token, password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+
+	selector, verifier, ok := splitResetToken(token)
+	if !ok {
+		return ErrInvalidResetToken
+	}
+
+	hash, err := GenerateHash(password)
+	if err != nil {
+		return err
+	}
+
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return err
+	}
+
+	var email string
+	err = dbManager.WithTransaction(ctx, nil, func(tx dbmanager.Tx) error {
+		// Lock the row so the token can only be used once.
+		user, err := dbmanager.QueryOne[resetTokenRow](ctx, tx.Querier(),
+			"SELECT user_id, email, recover_verifier, recover_expiry FROM users WHERE recover_selector = $1 FOR UPDATE;", selector)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrInvalidResetToken
+			}
+			return err
+		}
+		if err := user.check(verifier); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET password_hash = $1, recover_selector = '', recover_verifier = '', recover_expiry = $2 WHERE user_id = $3;",
+			hash, time.Now(), user.ID); err != nil {
+			return fmt.Errorf("error updating password: %v", err)
+		}
+
+		// Whoever knew the old password must not stay logged in.
+		if _, err := tx.ExecContext(ctx, "UPDATE session SET active = FALSE WHERE user_id = $1 AND active;", user.ID); err != nil {
+			return fmt.Errorf("error invalidating sessions: %v", err)
+		}
+
+		email = user.Email
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, ErrInvalidResetToken) {
+			logrus.Errorf("Error resetting password: %v", err)
+		}
+		return err
+	}
+
+	audit(ctx, AuditEvent{Type: AuditPasswordReset, Subject: email, Actor: email, Detail: "password reset with an emailed token"})
+
+	if t := DefaultThrottler(); t != nil {
+		if err := t.Succeed(ctx, email); err != nil {
+			logrus.Errorf("Error clearing login failures after password reset: %v", err)
+		}
+	}
+	return nil
+}
+
+// resetTokenRow is the part of a user row needed to check a reset token.
+type resetTokenRow struct {
+	ID       int       `db:"user_id"`
+	Email    string    `db:"email"`
+	Verifier string    `db:"recover_verifier"`
+	Expiry   time.Time `db:"recover_expiry"`
+}
+
+func (r resetTokenRow) check(verifier string) error {
+	if r.Verifier == "" || time.Now().After(r.Expiry) {
+		return ErrInvalidResetToken
+	}
+	if subtle.ConstantTimeCompare([]byte(hashVerifier(verifier)), []byte(r.Verifier)) != 1 {
+		return ErrInvalidResetToken
+	}
+	return nil
+}
+
+func resetTokenTTL() time.Duration {
+	if ttl := config.GetConfig().Auth.PasswordReset.TokenTTL; ttl > 0 {
+		return ttl
+	}
+	return defaultResetTokenTTL
+}
+
+func splitResetToken(token string) (selector, verifier string, ok bool) {
+	selector, verifier, ok = strings.Cut(token, ".")
+	return selector, verifier, ok && selector != "" && verifier != ""
+}
+
+func hashVerifier(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/ApolloMedTech/Middleware/alertManager"
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+	"github.com/ApolloMedTech/Middleware/localization"
+	"github.com/ApolloMedTech/Middleware/templateManager"
+	"github.com/flosch/pongo2/v6"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Paths of the password reset pages. After a successful reset the user is
+// sent to loginPath.
+const (
+	forgotPasswordPath = "/forgot-password"
+	resetPasswordPath  = "/reset-password"
+	loginPath          = "/login"
+)
+
+// localizationPrefix selects the strings passed to the reset templates, and
+// the alert messages, e.g. "password_reset_sent".
+const localizationPrefix = "password_reset_"
+
+// RegisterPasswordResetRoutes serves the pages to request a reset link and to
+// choose a new password, rendered from forgot_password.html and
+// reset_password.html in the templates path. The emails use
+// emails/password_reset.html and emails/password_reset.txt. The routes need
+// the sessions middleware for their alerts. It returns an error, registering
+// nothing, when auth.passwordReset.url isn't an absolute URL: the links in the
+// emails aren't derived from the request, whose Host header can be forged.
+func RegisterPasswordResetRoutes(router *gin.Engine) error {
+	resetURL := config.GetConfig().Auth.PasswordReset.URL
+	if u, err := url.Parse(resetURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("auth.passwordReset.url must be the absolute URL of the reset page, got %q", resetURL)
+	}
+
+	templates := config.GetConfig().Templates.Path
+
+	router.GET(forgotPasswordPath, func(c *gin.Context) {
+		strings := localization.LocalizePrefixStrings(c, localizationPrefix)
+		templateManager.Render(c, templates+"/forgot_password.html", pongo2.Context{}, &strings)
+	})
+
+	router.POST(forgotPasswordPath, func(c *gin.Context) {
+		// The email is sent in the background, so the answer is the same, and
+		// as quick, whether or not the account exists.
+		if email := c.PostForm("email"); email != "" {
+			ctx := context.WithoutCancel(dbmanager.RequestContext(c))
+			go func() {
+				if err := SendPasswordReset(ctx, email, resetURL); err != nil {
+					logrus.Errorf("Error sending password reset email: %v", err)
+				}
+			}()
+		}
+
+		alertManager.AddAlert(c, localizedMessage(c, "sent", "If an account exists for this email, a reset link has been sent to it."), alertManager.AlertInfo)
+		c.Redirect(http.StatusSeeOther, forgotPasswordPath)
+	})
+
+	router.GET(resetPasswordPath, func(c *gin.Context) {
+		token := c.Query("token")
+		if err := ValidateResetToken(dbmanager.RequestContext(c), token); err != nil {
+			invalidToken(c, err)
+			return
+		}
+
+		strings := localization.LocalizePrefixStrings(c, localizationPrefix)
+		templateManager.Render(c, templates+"/reset_password.html", pongo2.Context{"token": token, "minLength": MinPasswordLength}, &strings)
+	})
+
+	router.POST(resetPasswordPath, func(c *gin.Context) {
+		token := c.PostForm("token")
+		password := c.PostForm("password")
+		retry := resetPasswordPath + "?token=" + url.QueryEscape(token)
+
+		if password != c.PostForm("password_confirm") {
+			alertManager.AddAlert(c, localizedMessage(c, "mismatch", "The passwords don't match."), alertManager.AlertDanger)
+			c.Redirect(http.StatusSeeOther, retry)
+			return
+		}
+
+		err := ResetPassword(dbmanager.RequestContext(c), token, password)
+		switch {
+		case err == nil:
+			alertManager.AddAlert(c, localizedMessage(c, "done", "Your password has been changed, you can now log in."), alertManager.AlertSuccess)
+			c.Redirect(http.StatusSeeOther, loginPath)
+		case errors.Is(err, ErrPasswordTooShort):
+			alertManager.AddAlert(c, localizedMessage(c, "too_short", err.Error()), alertManager.AlertDanger)
+			c.Redirect(http.StatusSeeOther, retry)
+		default:
+			invalidToken(c, err)
+		}
+	})
+	return nil
+}
+
+// invalidToken sends the user back to request a new link.
+func invalidToken(c *gin.Context, err error) {
+	if !errors.Is(err, ErrInvalidResetToken) {
+		logrus.Errorf("Error checking password reset token: %v", err)
+	}
+	alertManager.AddAlert(c, localizedMessage(c, "invalid", "This reset link is invalid or has expired, please request a new one."), alertManager.AlertDanger)
+	c.Redirect(http.StatusSeeOther, forgotPasswordPath)
+}
+
+// localizedMessage returns the localized string localizationPrefix+key, or
+// fallback when the locales don't define it.
+func localizedMessage(c *gin.Context, key, fallback string) string {
+	if s, ok := localization.LocalizePrefixStrings(c, localizationPrefix+key)[localizationPrefix+key]; ok {
+		return s
+	}
+	return fallback
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/gob"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/alertManager"
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/ApolloMedTech/Middleware/localization"
+	"github.com/ApolloMedTech/Middleware/mailManager"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+)
+
+// blockingMailer hands the messages sent to it over sent, once release is
+// closed.
+type blockingMailer struct {
+	release chan struct{}
+	sent    chan mailManager.Message
+}
+
+func (m blockingMailer) Send(_ context.Context, msg mailManager.Message) error {
+	<-m.release
+	m.sent <- msg
+	return nil
+}
+
+func TestForgotPasswordSameWork(t *testing.T) {
+	cfg := config.GetConfig()
+	previousURL, previousTemplates := cfg.Auth.PasswordReset.URL, cfg.Templates.Path
+	cfg.Auth.PasswordReset.URL, cfg.Templates.Path = "https://apollo.example/reset-password", "../templates"
+	t.Cleanup(func() { cfg.Auth.PasswordReset.URL, cfg.Templates.Path = previousURL, previousTemplates })
+
+	mailer := blockingMailer{release: make(chan struct{}), sent: make(chan mailManager.Message, 1)}
+	mailManager.SetMailer(mailer)
+	t.Cleanup(func() { mailManager.SetMailer(nil) })
+
+	// Only ana@example.com has an account. updates receives the email of
+	// every token update.
+	updates := make(chan string, 2)
+	useFakeDB(t, &fakeDB{exec: func(query string, args []driver.Value) int64 {
+		if !strings.HasPrefix(query, "UPDATE users SET recover_selector") {
+			return 0
+		}
+		updates <- args[3].(string)
+		if args[3] == "ana@example.com" {
+			return 1
+		}
+		return 0
+	}})
+
+	gob.Register([]alertManager.Alert{})
+	localization.InitLocalization(config.LocalizationConfig{LocalesPath: t.TempDir()})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(sessions.Sessions("alerts", cookie.NewStore([]byte("test"))), localization.LocalizationMiddleware())
+	if err := RegisterPasswordResetRoutes(router); err != nil {
+		t.Fatalf("RegisterPasswordResetRoutes: %v", err)
+	}
+
+	// forgot posts email to the form, failing unless it answers while the
+	// mailer is still blocked.
+	forgot := func(email string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, forgotPasswordPath, strings.NewReader(url.Values{"email": {email}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+
+		answered := make(chan struct{})
+		go func() {
+			router.ServeHTTP(w, r)
+			close(answered)
+		}()
+		select {
+		case <-answered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no answer until the email is sent", email)
+		}
+		return w
+	}
+
+	for _, email := range []string{"ana@example.com", "nobody@example.com"} {
+		w := forgot(email)
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != forgotPasswordPath {
+			t.Errorf("%s: answered %d to %q, want %d to %q", email, w.Code, w.Header().Get("Location"), http.StatusSeeOther, forgotPasswordPath)
+		}
+
+		select {
+		case updated := <-updates:
+			if updated != email {
+				t.Errorf("%s: token issued for %s", email, updated)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no token was issued", email)
+		}
+	}
+
+	close(mailer.release)
+	select {
+	case msg := <-mailer.sent:
+		if len(msg.To) != 1 || msg.To[0] != "ana@example.com" || !strings.Contains(msg.Text, "https://apollo.example/reset-password?token=") {
+			t.Errorf("sent %+v, want a reset link to ana@example.com", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reset email was sent")
+	}
+	select {
+	case msg := <-mailer.sent:
+		t.Errorf("sent %+v to an unknown account", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/ApolloMedTech/Middleware/alertManager"
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/ApolloMedTech/Middleware/localization"
+	"github.com/ApolloMedTech/Middleware/sessionmanager"
+	"github.com/gin-gonic/gin"
+)
+
+// sessionExpiredMessage is the localized alert shown when asking to log in
+// again.
+const sessionExpiredMessage = "session_expired"
+
+// RequireSession lets the request through only with a valid session. When the
+// session timed out or was revoked it asks the user to log in again: JSON
+// clients get 401 with "reauthenticate" set, browsers are sent to
+// SessionConfig.ReauthPath with an alert and the original address in the
+// "next" query parameter. Requests without any session are sent there
+// without the alert.
+func RequireSession(store *sessionmanager.MySessionStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, err := store.LoadUser(c.Writer, c.Request)
+		if err == nil {
+			c.Next()
+			return
+		}
+
+		expired := errors.Is(err, sessionmanager.ErrSessionExpired)
+		if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required", "reauthenticate": expired})
+			return
+		}
+
+		if expired {
+			message := "Your session has expired, please log in again."
+			if s, ok := localization.LocalizePrefixStrings(c, sessionExpiredMessage)[sessionExpiredMessage]; ok {
+				message = s
+			}
+			alertManager.AddAlert(c, message, alertManager.AlertWarning)
+		}
+
+		target := config.GetConfig().Session.ReauthPath
+		if target == "" {
+			target = loginPath
+		}
+		c.Redirect(http.StatusSeeOther, target+"?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+		c.Abort()
+	}
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC's 8 digit SHA-1 values, truncated to the 6 digits used here.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(time.Unix(tt.unix, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			code, err := TOTPCode(rfc6238Secret, time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Fatalf("TOTPCode: %v", err)
+			}
+			if code != tt.want {
+				t.Errorf("TOTPCode = %s, want %s", code, tt.want)
+			}
+
+			step, ok := ValidateTOTP(rfc6238Secret, tt.want, time.Unix(tt.unix, 0), 0)
+			if !ok || step != tt.unix/30 {
+				t.Errorf("ValidateTOTP = %d, %v, want %d, true", step, ok, tt.unix/30)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / 30
+	codeAt := func(offset time.Duration) string {
+		code, err := TOTPCode(rfc6238Secret, now.Add(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		skew     int
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current step", secret: rfc6238Secret, code: codeAt(0), skew: 1, wantStep: current, wantOK: true},
+		{name: "previous step within skew", secret: rfc6238Secret, code: codeAt(-30 * time.Second), skew: 1, wantStep: current - 1, wantOK: true},
+		{name: "next step within skew", secret: rfc6238Secret, code: codeAt(30 * time.Second), skew: 1, wantStep: current + 1, wantOK: true},
+		{name: "previous step without skew", secret: rfc6238Secret, code: codeAt(-30 * time.Second), skew: 0},
+		{name: "two steps back with a skew of one", secret: rfc6238Secret, code: codeAt(-60 * time.Second), skew: 1},
+		{name: "two steps ahead with a skew of two", secret: rfc6238Secret, code: codeAt(60 * time.Second), skew: 2, wantStep: current + 2, wantOK: true},
+		{name: "secret typed in lower case with spaces", secret: "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", code: codeAt(0), skew: 1, wantStep: current, wantOK: true},
+		{name: "wrong code", secret: rfc6238Secret, code: "000000", skew: 1},
+		{name: "too short", secret: rfc6238Secret, code: codeAt(0)[:5], skew: 1},
+		{name: "too long", secret: rfc6238Secret, code: codeAt(0) + "0", skew: 1},
+		{name: "invalid secret", secret: "not base32!", code: codeAt(0), skew: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(tt.secret, tt.code, now, tt.skew)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("ValidateTOTP = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret: %v", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("secret %q has %d characters, want 32", secret, len(secret))
+	}
+
+	uri, err := url.Parse(TOTPURI("Apollo Health", "ana@example.com", secret))
+	if err != nil {
+		t.Fatalf("TOTPURI isn't a URL: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Apollo Health:ana@example.com" {
+		t.Errorf("TOTPURI = %s, want otpauth://totp/Apollo Health:ana@example.com", uri)
+	}
+	query := uri.Query()
+	for key, want := range map[string]string{"secret": secret, "issuer": "Apollo Health", "digits": "6", "period": "30", "algorithm": "SHA1"} {
+		if got := query.Get(key); got != want {
+			t.Errorf("TOTPURI %s = %q, want %q", key, got, want)
+		}
+	}
+}
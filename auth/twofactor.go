@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrTOTPNotEnrolled is returned when the user has no confirmed TOTP secret.
+	ErrTOTPNotEnrolled = errors.New("two-factor authentication is not set up")
+	// ErrTOTPAlreadyEnabled is returned when enrolling a user whose TOTP secret
+	// is already confirmed; disable it first.
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrInvalidSecondFactor is returned for wrong, reused and expired codes.
+	ErrInvalidSecondFactor = errors.New("invalid authentication code")
+)
+
+const (
+	defaultTOTPIssuer = "Apollo"
+	defaultTOTPSkew   = 1
+
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 5 // 8 base32 characters
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPEnrollment is what the user needs to add the account to an
+// authenticator app: the secret to type in, or the URI to scan as a QR code.
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// EnrollTOTP generates a TOTP secret for user. It only takes effect once
+// ConfirmTOTP is called with a code from the app; until then enrolling again
+// replaces it.
+func EnrollTOTP(ctx context.Context, user *config.ApolloUser) (*TOTPEnrollment, error) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = dbmanager.QueryOne[int](ctx, dbManager, `INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = now() WHERE NOT user_totp.confirmed
+		RETURNING user_id;`, user.ID, secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTOTPAlreadyEnabled
+		}
+		logrus.Errorf("Error enrolling TOTP for user %d: %v", user.ID, err)
+		return nil, err
+	}
+
+	return &TOTPEnrollment{Secret: secret, URI: TOTPURI(totpIssuer(), user.Email, secret)}, nil
+}
+
+// ConfirmTOTP enables the secret from EnrollTOTP once the user proves the app
+// has it, and returns a fresh set of recovery codes to show exactly once.
+func ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, error) {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = dbManager.WithTransaction(ctx, nil, func(tx dbmanager.Tx) error {
+		totp, err := dbmanager.QueryOne[userTOTP](ctx, tx.Querier(), "SELECT secret, confirmed FROM user_totp WHERE user_id = $1 FOR UPDATE;", userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrTOTPNotEnrolled
+			}
+			return err
+		}
+		if totp.Confirmed {
+			return ErrTOTPAlreadyEnabled
+		}
+
+		step, ok := ValidateTOTP(totp.Secret, code, time.Now(), totpSkew())
+		if !ok {
+			return ErrInvalidSecondFactor
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE user_totp SET confirmed = TRUE, last_used_step = $1 WHERE user_id = $2;", step, userID); err != nil {
+			return fmt.Errorf("error confirming TOTP: %v", err)
+		}
+
+		codes, err = replaceRecoveryCodes(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	audit(ctx, AuditEvent{Type: AuditSecondFactorEnabled, Subject: strconv.Itoa(userID), Actor: strconv.Itoa(userID), Detail: "TOTP enabled"})
+	return codes, nil
+}
+
+// TOTPEnabled reports whether the user has a confirmed TOTP secret.
+func TOTPEnabled(ctx context.Context, userID int) (bool, error) {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return false, err
+	}
+
+	confirmed, err := dbmanager.QueryOne[bool](dbmanager.ReadYourWrites(ctx), dbManager, "SELECT confirmed FROM user_totp WHERE user_id = $1;", userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return confirmed, err
+}
+
+// VerifySecondFactor checks a TOTP code or an unused recovery code of the
+// user. Each TOTP code and recovery code is accepted only once, and repeated
+// failures are throttled like logins.
+func VerifySecondFactor(ctx context.Context, userID int, code string) error {
+	t := DefaultThrottler()
+	key := secondFactorKey(userID)
+	if t != nil {
+		if err := t.check(ctx, key, t.cfg.MaxFailures); err != nil {
+			return err
+		}
+	}
+
+	err := verifySecondFactor(ctx, userID, code)
+
+	if t != nil {
+		var recordErr error
+		switch {
+		case err == nil:
+			recordErr = t.store.Reset(ctx, key)
+		case errors.Is(err, ErrInvalidSecondFactor):
+			recordErr = t.fail(ctx, key, t.cfg.MaxFailures, AuditEvent{Type: AuditSecondFactorLocked, Subject: strconv.Itoa(userID)})
+		}
+		if recordErr != nil {
+			logrus.Errorf("Error recording second factor attempt for user %d: %v", userID, recordErr)
+		}
+	}
+	return err
+}
+
+func verifySecondFactor(ctx context.Context, userID int, code string) error {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return err
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return useRecoveryCode(ctx, dbManager, userID, code)
+	}
+
+	secret, err := dbmanager.QueryOne[string](dbmanager.ReadYourWrites(ctx), dbManager, "SELECT secret FROM user_totp WHERE user_id = $1 AND confirmed;", userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTOTPNotEnrolled
+		}
+		return err
+	}
+
+	step, ok := ValidateTOTP(secret, code, time.Now(), totpSkew())
+	if !ok {
+		return ErrInvalidSecondFactor
+	}
+
+	// Only move forward, so neither this code nor an older one works again.
+	rows, err := dbManager.UpdateContext(ctx, "UPDATE user_totp SET last_used_step = $1 WHERE user_id = $2 AND confirmed AND last_used_step < $1;", step, userID)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvalidSecondFactor
+	}
+	return nil
+}
+
+// useRecoveryCode marks an unused recovery code of the user as used.
+func useRecoveryCode(ctx context.Context, dbManager *dbmanager.DBManager, userID int, code string) error {
+	rows, err := dbManager.UpdateContext(ctx, "UPDATE user_recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;",
+		userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvalidSecondFactor
+	}
+
+	audit(ctx, AuditEvent{Type: AuditRecoveryCodeUsed, Subject: strconv.Itoa(userID), Actor: strconv.Itoa(userID), Detail: "signed in with a recovery code"})
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of a user with TOTP
+// enabled, e.g. after most have been used up.
+func RegenerateRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	enabled, err := TOTPEnabled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = dbManager.WithTransaction(ctx, nil, func(tx dbmanager.Tx) error {
+		codes, err = replaceRecoveryCodes(ctx, tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// DisableTOTP removes the TOTP secret and recovery codes of a user, on behalf
+// of actor (the user or an administrator).
+func DisableTOTP(ctx context.Context, userID int, actor string) error {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return err
+	}
+
+	err = dbManager.WithTransaction(ctx, nil, func(tx dbmanager.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = $1;", userID); err != nil {
+			return fmt.Errorf("error deleting recovery codes: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = $1;", userID); err != nil {
+			return fmt.Errorf("error deleting TOTP secret: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Error disabling TOTP for user %d: %v", userID, err)
+		return err
+	}
+
+	audit(ctx, AuditEvent{Type: AuditSecondFactorDisabled, Subject: strconv.Itoa(userID), Actor: actor, Detail: "TOTP disabled"})
+	return nil
+}
+
+// userTOTP is a row of user_totp.
+type userTOTP struct {
+	Secret    string `db:"secret"`
+	Confirmed bool   `db:"confirmed"`
+}
+
+// replaceRecoveryCodes stores new recovery codes for the user, hashed, and
+// returns them in clear.
+func replaceRecoveryCodes(ctx context.Context, tx dbmanager.Tx, userID int) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = $1;", userID); err != nil {
+		return nil, fmt.Errorf("error deleting recovery codes: %v", err)
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("error generating recovery code: %v", err)
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+
+		if _, err := tx.ExecContext(ctx, "INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2);", userID, hashRecoveryCode(code)); err != nil {
+			return nil, fmt.Errorf("error storing recovery code: %v", err)
+		}
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode hashes a recovery code ignoring case and separators.
+// Codes are random enough that a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func secondFactorKey(userID int) string {
+	return "2fa:" + strconv.Itoa(userID)
+}
+
+func totpIssuer() string {
+	if issuer := config.GetConfig().Auth.TwoFactor.Issuer; issuer != "" {
+		return issuer
+	}
+	return defaultTOTPIssuer
+}
+
+func totpSkew() int {
+	if skew := config.GetConfig().Auth.TwoFactor.Skew; skew > 0 {
+		return skew
+	}
+	return defaultTOTPSkew
+}
@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultRPDisplayName = "Apollo"
+
+// ErrClonedAuthenticator is returned when the signature counter of a
+// credential went backwards, meaning the key may have been copied.
+var ErrClonedAuthenticator = errors.New("authenticator may have been cloned")
+
+var (
+	relyingParty     *webauthn.WebAuthn
+	relyingPartyErr  error
+	relyingPartyOnce sync.Once
+)
+
+// WebAuthn returns the relying party configured by WebAuthnConfig.
+func WebAuthn() (*webauthn.WebAuthn, error) {
+	relyingPartyOnce.Do(func() {
+		cfg := config.GetConfig().Auth.WebAuthn
+		if cfg.RPDisplayName == "" {
+			cfg.RPDisplayName = defaultRPDisplayName
+		}
+
+		relyingParty, relyingPartyErr = webauthn.New(&webauthn.Config{
+			RPID:          cfg.RPID,
+			RPDisplayName: cfg.RPDisplayName,
+			RPOrigins:     cfg.RPOrigins,
+			AuthenticatorSelection: protocol.AuthenticatorSelection{
+				ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+				UserVerification: protocol.VerificationPreferred,
+			},
+			Timeouts: webauthn.TimeoutsConfig{
+				Login:        webauthn.TimeoutConfig{Enforce: true},
+				Registration: webauthn.TimeoutConfig{Enforce: true},
+			},
+		})
+		if relyingPartyErr != nil {
+			relyingPartyErr = fmt.Errorf("error configuring WebAuthn: %v", relyingPartyErr)
+		}
+	})
+	return relyingParty, relyingPartyErr
+}
+
+// webAuthnUser adapts ApolloUser to webauthn.User. The user handle is the
+// user ID, which lets passkey logins find the user without an email.
+type webAuthnUser struct {
+	*config.ApolloUser
+	credentials []webauthn.Credential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return []byte(strconv.Itoa(u.ID))
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	if u.Name != "" {
+		return u.Name
+	}
+	return u.Email
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (u *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+// BeginWebAuthnRegistration starts adding a security key or passkey to user.
+// The options go to navigator.credentials.create() in the browser, the
+// session data must be kept for FinishWebAuthnRegistration.
+func BeginWebAuthnRegistration(ctx context.Context, user *config.ApolloUser) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	rp, err := WebAuthn()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wu, err := loadWebAuthnUser(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, len(wu.credentials))
+	for i, credential := range wu.credentials {
+		exclusions[i] = credential.Descriptor()
+	}
+
+	return rp.BeginRegistration(wu, webauthn.WithExclusions(exclusions))
+}
+
+// FinishWebAuthnRegistration verifies the browser's response in r and stores
+// the new credential under name.
+func FinishWebAuthnRegistration(ctx context.Context, user *config.ApolloUser, session webauthn.SessionData, r *http.Request, name string) error {
+	rp, err := WebAuthn()
+	if err != nil {
+		return err
+	}
+
+	wu, err := loadWebAuthnUser(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	credential, err := rp.FinishRegistration(wu, session, r)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credential to JSON: %v", err)
+	}
+
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return err
+	}
+
+	_, err = dbManager.UpdateContext(ctx, "INSERT INTO webauthn_credentials (credential_id, user_id, credential, name) VALUES ($1, $2, $3, $4);",
+		credential.ID, user.ID, data, name)
+	if err != nil {
+		return err
+	}
+
+	audit(ctx, AuditEvent{Type: AuditWebAuthnRegistered, Subject: user.Email, Actor: user.Email, Detail: fmt.Sprintf("security key %q registered", name)})
+	return nil
+}
+
+// BeginWebAuthnLogin starts a passkey login, where the browser offers the
+// credentials it has for this site. The options go to
+// navigator.credentials.get(), the session data must be kept for
+// FinishWebAuthnLogin.
+func BeginWebAuthnLogin() (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	rp, err := WebAuthn()
+	if err != nil {
+		return nil, nil, err
+	}
+	return rp.BeginDiscoverableLogin()
+}
+
+// FinishWebAuthnLogin verifies the browser's response in r and returns the
+// user it logs in, along with whether the authenticator verified the user
+// (PIN or biometrics), which also counts as a second factor.
+func FinishWebAuthnLogin(ctx context.Context, session webauthn.SessionData, r *http.Request) (*config.ApolloUser, bool, error) {
+	return FinishWebAuthnLoginFromIP(ctx, session, r, "")
+}
+
+// FinishWebAuthnLoginFromIP is like FinishWebAuthnLogin and also throttles
+// failed attempts like LoginFromIP, by the account the response claims and by
+// the client IP address. While either has to wait it returns a
+// *ThrottledError without verifying the response.
+func FinishWebAuthnLoginFromIP(ctx context.Context, session webauthn.SessionData, r *http.Request, ip string) (*config.ApolloUser, bool, error) {
+	rp, err := WebAuthn()
+	if err != nil {
+		return nil, false, err
+	}
+
+	response, err := protocol.ParseCredentialRequestResponse(r)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The user handle names the account before the response is verified.
+	userID, err := strconv.Atoi(string(response.Response.UserHandle))
+	if err != nil {
+		return nil, false, protocol.ErrBadRequest.WithDetails("invalid user handle")
+	}
+	user, err := loadUserByID(ctx, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	wu, err := loadWebAuthnUser(ctx, user)
+	if err != nil {
+		return nil, false, err
+	}
+
+	t := DefaultThrottler()
+	if t != nil {
+		if err := t.Check(ctx, user.Email, ip); err != nil {
+			return nil, false, err
+		}
+	}
+
+	credential, err := rp.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		return wu, nil
+	}, session, response)
+	if err == nil && credential.Authenticator.CloneWarning {
+		audit(ctx, AuditEvent{Type: AuditWebAuthnCloned, Subject: user.Email, Actor: "system", Detail: "signature counter went backwards"})
+		err = ErrClonedAuthenticator
+	}
+
+	if t != nil {
+		// Any response that doesn't verify counts like a wrong password.
+		var loginErr error
+		if err != nil {
+			loginErr = ErrInvalidCredentials
+		}
+		recordLoginResult(ctx, t, user.Email, ip, loginErr)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := updateWebAuthnCredential(ctx, credential); err != nil {
+		logrus.Errorf("Error updating WebAuthn credential of user %d: %v", user.ID, err)
+	}
+
+	return user, credential.Flags.UserVerified, nil
+}
+
+// WebAuthnCredential is a security key or passkey registered by a user.
+type WebAuthnCredential struct {
+	ID         []byte    `db:"credential_id"`
+	Name       string    `db:"name"`
+	CreatedAt  time.Time `db:"created_at"`
+	LastUsedAt time.Time `db:"last_used_at"`
+}
+
+// ListWebAuthnCredentials returns the credentials registered by the user.
+func ListWebAuthnCredentials(ctx context.Context, userID int) ([]WebAuthnCredential, error) {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return nil, err
+	}
+
+	return dbmanager.QueryAll[WebAuthnCredential](dbmanager.ReadYourWrites(ctx), dbManager,
+		"SELECT credential_id, name, created_at, last_used_at FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at;", userID)
+}
+
+// DeleteWebAuthnCredential removes one of the user's credentials.
+func DeleteWebAuthnCredential(ctx context.Context, userID int, credentialID []byte) error {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return err
+	}
+
+	rows, err := dbManager.DeleteContext(ctx, "DELETE FROM webauthn_credentials WHERE user_id = $1 AND credential_id = $2;", userID, credentialID)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// loadUserByID loads a user without the password hash.
+func loadUserByID(ctx context.Context, userID int) (*config.ApolloUser, error) {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := dbmanager.QueryOne[config.ApolloUser](ctx, dbManager, "SELECT user_id, name, email, user_type FROM users WHERE user_id = $1;", userID)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// loadWebAuthnUser loads the credentials of user.
+func loadWebAuthnUser(ctx context.Context, user *config.ApolloUser) (*webAuthnUser, error) {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dbmanager.QueryAll[[]byte](dbmanager.ReadYourWrites(ctx), dbManager, "SELECT credential FROM webauthn_credentials WHERE user_id = $1;", user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	wu := &webAuthnUser{ApolloUser: user, credentials: make([]webauthn.Credential, len(rows))}
+	for i, data := range rows {
+		if err := json.Unmarshal(data, &wu.credentials[i]); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal credential: %v", err)
+		}
+	}
+	return wu, nil
+}
+
+// updateWebAuthnCredential stores the new signature counter and flags after
+// a login.
+func updateWebAuthnCredential(ctx context.Context, credential *webauthn.Credential) error {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credential to JSON: %v", err)
+	}
+
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return err
+	}
+
+	_, err = dbManager.UpdateContext(ctx, "UPDATE webauthn_credentials SET credential = $1, last_used_at = now() WHERE credential_id = $2;", data, credential.ID)
+	return err
+}
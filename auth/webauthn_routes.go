@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+	"github.com/ApolloMedTech/Middleware/sessionmanager"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/sirupsen/logrus"
+)
+
+// webAuthnSessionKey holds the state of a registration or login ceremony
+// between its begin and finish requests.
+const webAuthnSessionKey = "WebAuthn"
+
+// RegisterWebAuthnRoutes serves the JSON endpoints used by the browser for
+// WebAuthn:
+//
+//	POST   /webauthn/register/begin   options for navigator.credentials.create()
+//	POST   /webauthn/register/finish  stores the new credential, ?name= labels it
+//	GET    /webauthn/credentials      lists the user's credentials
+//	DELETE /webauthn/credentials/:id  removes one, by base64url ID
+//	POST   /webauthn/login/begin      options for navigator.credentials.get()
+//	POST   /webauthn/login/finish     logs the user in like auth.Login
+//
+// All but the login routes need a logged in user in store, who has completed
+// their second factor when they require one, so a stolen password can't be
+// used to add or remove passkeys. Failed logins are throttled like password
+// logins, by account and client IP address.
+func RegisterWebAuthnRoutes(router *gin.Engine, store *sessionmanager.MySessionStore) {
+	group := router.Group("/webauthn")
+
+	group.POST("/register/begin", func(c *gin.Context) {
+		user, ok := loadCredentialOwner(c, store)
+		if !ok {
+			return
+		}
+
+		options, session, err := BeginWebAuthnRegistration(dbmanager.RequestContext(c), user)
+		if err != nil {
+			webAuthnError(c, err)
+			return
+		}
+		if err := store.SaveObject(c.Writer, c.Request, webAuthnSessionKey, session); err != nil {
+			webAuthnError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, options)
+	})
+
+	group.POST("/register/finish", func(c *gin.Context) {
+		user, ok := loadCredentialOwner(c, store)
+		if !ok {
+			return
+		}
+
+		session, err := takeWebAuthnSession(c, store)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := FinishWebAuthnRegistration(dbmanager.RequestContext(c), user, *session, c.Request, c.Query("name")); err != nil {
+			webAuthnError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	group.GET("/credentials", func(c *gin.Context) {
+		user, ok := loadCredentialOwner(c, store)
+		if !ok {
+			return
+		}
+
+		credentials, err := ListWebAuthnCredentials(dbmanager.RequestContext(c), user.ID)
+		if err != nil {
+			webAuthnError(c, err)
+			return
+		}
+		// IDs in the same base64url form DELETE expects.
+		list := make([]gin.H, len(credentials))
+		for i, credential := range credentials {
+			list[i] = gin.H{
+				"id":         base64.RawURLEncoding.EncodeToString(credential.ID),
+				"name":       credential.Name,
+				"createdAt":  credential.CreatedAt,
+				"lastUsedAt": credential.LastUsedAt,
+			}
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	group.DELETE("/credentials/:id", func(c *gin.Context) {
+		user, ok := loadCredentialOwner(c, store)
+		if !ok {
+			return
+		}
+
+		id, err := base64.RawURLEncoding.DecodeString(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid credential ID"})
+			return
+		}
+
+		if err := DeleteWebAuthnCredential(dbmanager.RequestContext(c), user.ID, id); err != nil {
+			webAuthnError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	group.POST("/login/begin", func(c *gin.Context) {
+		options, session, err := BeginWebAuthnLogin()
+		if err != nil {
+			webAuthnError(c, err)
+			return
+		}
+		if err := store.SaveObject(c.Writer, c.Request, webAuthnSessionKey, session); err != nil {
+			webAuthnError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, options)
+	})
+
+	group.POST("/login/finish", func(c *gin.Context) {
+		session, err := takeWebAuthnSession(c, store)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, verified, err := FinishWebAuthnLoginFromIP(dbmanager.RequestContext(c), *session, c.Request, c.ClientIP())
+		var throttled *ThrottledError
+		if errors.As(err, &throttled) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			logrus.Warnf("WebAuthn login failed: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication failed"})
+			return
+		}
+
+		if err := store.StartSession(c.Writer, c.Request, user); err != nil {
+			webAuthnError(c, err)
+			return
+		}
+		// A key that checked a PIN or biometric is already two factors.
+		if verified {
+			if err := store.CompleteSecondFactor(c.Writer, c.Request); err != nil {
+				logrus.Errorf("Error marking second factor for user %d: %v", user.ID, err)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "user": user})
+	})
+}
+
+// takeWebAuthnSession returns the ceremony state saved by the begin request
+// and removes it, so it can't be replayed.
+func takeWebAuthnSession(c *gin.Context, store *sessionmanager.MySessionStore) (*webauthn.SessionData, error) {
+	value, err := store.Load(c.Writer, c.Request, webAuthnSessionKey)
+	if err != nil || value == "" {
+		return nil, errors.New("no WebAuthn ceremony in progress")
+	}
+	if err := store.Save(c.Writer, c.Request, webAuthnSessionKey, ""); err != nil {
+		return nil, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(value), &session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal WebAuthn session: %v", err)
+	}
+	return &session, nil
+}
+
+// loadCredentialOwner returns the logged in user allowed to manage their
+// credentials, or aborts the request: with 401 without a user, and with 403
+// while the user still has to complete their second factor.
+func loadCredentialOwner(c *gin.Context, store *sessionmanager.MySessionStore) (*config.ApolloUser, bool) {
+	user, err := store.LoadUser(c.Writer, c.Request)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if RequiresSecondFactor(user) && !store.SecondFactorCompleted(c.Writer, c.Request) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "second factor required"})
+		return nil, false
+	}
+	return user, true
+}
+
+// webAuthnError answers 400 for responses the browser got wrong, 404 for
+// unknown credentials and 500 for anything else.
+func webAuthnError(c *gin.Context, err error) {
+	var protocolErr *protocol.Error
+	switch {
+	case errors.As(err, &protocolErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": protocolErr.Details})
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "credential not found"})
+		return
+	}
+
+	logrus.Errorf("WebAuthn error: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
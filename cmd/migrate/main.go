@@ -0,0 +1,89 @@
+// Command migrate applies or reverts the middleware's database migrations.
+//
+// Usage:
+//
+//	migrate [-config config.yaml] up
+//	migrate [-config config.yaml] [-steps 1] down
+//	migrate [-config config.yaml] version
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "path to the YAML configuration file")
+	steps := flag.Int("steps", 1, "number of migrations to revert with down")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] up|down|version\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config.LoadConfig(*configPath)
+
+	err := run(context.Background(), flag.Arg(0), *steps)
+	if errors.Is(err, errUsage) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+}
+
+// errUsage is returned by run for an unknown command.
+var errUsage = errors.New("unknown command")
+
+// run carries out command against the configured database. It returns instead
+// of exiting, so that the pool is closed on failure as well.
+func run(ctx context.Context, command string, steps int) error {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %v", err)
+	}
+	defer dbmanager.ClosePool()
+
+	migrator, err := dbmanager.NewMigrator(dbManager.DB, config.GetConfig().Database.Driver, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %v", err)
+	}
+
+	switch command {
+	case "up":
+		count, err := migrator.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("migration failed: %v", err)
+		}
+		logrus.Infof("Applied %d migration(s)", count)
+	case "down":
+		count, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return fmt.Errorf("migration failed: %v", err)
+		}
+		logrus.Infof("Reverted %d migration(s)", count)
+	case "version":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read schema version: %v", err)
+		}
+		fmt.Println(version)
+	default:
+		return errUsage
+	}
+	return nil
+}
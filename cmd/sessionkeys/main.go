@@ -0,0 +1,37 @@
+// Command sessionkeys prints a new session key pair, to put first in
+// session.keys when rotating keys.
+//
+// Usage:
+//
+//	sessionkeys [-encryption 32]
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/ApolloMedTech/Middleware/sessionmanager"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	encryptionSize := flag.Int("encryption", 32, "size of the encryption key in bytes: 16, 24 or 32")
+	flag.Parse()
+
+	switch *encryptionSize {
+	case 16, 24, 32:
+	default:
+		logrus.Fatalf("Invalid encryption key size %d, want 16, 24 or 32", *encryptionSize)
+	}
+
+	authentication, err := sessionmanager.GenerateSessionKey(64)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	encryption, err := sessionmanager.GenerateSessionKey(*encryptionSize)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	fmt.Printf("- authentication: %s\n  encryption: %s\n", authentication, encryption)
+}
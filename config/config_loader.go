@@ -4,6 +4,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 	"os"
+	"strings"
 	"sync"
 )
 
@@ -34,7 +35,7 @@ func LoadConfig(filePath string) {
 			config.Database.User = user
 		}
 		if password := os.Getenv("DB_PASSWORD"); password != "" {
-			config.Database.Password = password
+			config.Database.Password = Redacted(password)
 		}
 		if host := os.Getenv("DB_HOST"); host != "" {
 			config.Database.Host = host
@@ -45,9 +46,41 @@ func LoadConfig(filePath string) {
 		if dbName := os.Getenv("DB_NAME"); dbName != "" {
 			config.Database.Name = dbName
 		}
+		if driver := os.Getenv("DB_DRIVER"); driver != "" {
+			config.Database.Driver = driver
+		}
+		if dsn := os.Getenv("DB_DSN"); dsn != "" {
+			config.Database.DSN = dsn
+		}
+		if sslMode := os.Getenv("DB_SSLMODE"); sslMode != "" {
+			config.Database.SSLMode = sslMode
+		}
+		// SESSION_KEYS is "auth:enc,oldAuth:oldEnc", newest pair first.
+		if keys := os.Getenv("SESSION_KEYS"); keys != "" {
+			config.Session.Keys = parseSessionKeys(keys)
+		}
+
+		// Checked once here so the session stores can rely on them.
+		if _, err := config.Session.KeyPairs(); err != nil {
+			logrus.Fatalf("Invalid session keys: %s", err)
+		}
 	})
 }
 
+// parseSessionKeys splits the SESSION_KEYS variable into key pairs.
+func parseSessionKeys(value string) []SessionKeyConfig {
+	var keys []SessionKeyConfig
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		authentication, encryption, _ := strings.Cut(pair, ":")
+		keys = append(keys, SessionKeyConfig{Authentication: Redacted(authentication), Encryption: Redacted(encryption)})
+	}
+	return keys
+}
+
 // GetConfig returns the global configuration
 func GetConfig() *Config {
 	return &config
@@ -1,14 +1,21 @@
 package config
 
+import "time"
+
 // Config armazena as configurações do aplicativo.
 type Config struct {
-	Assets       AssetsConfig       `yaml:"assets"`
-	StaticConfig StaticConfig       `yaml:"static_config"`
-	Templates    TemplatesConfig    `yaml:"templates"`
-	Database     DatabaseConfig     `yaml:"database"`
-	ServerConfig ServerConfig       `yaml:"server"`
-	LogConfig    LogConfig          `yaml:"log"`
-	Localization LocalizationConfig `yaml:"localization"`
+	Assets        AssetsConfig        `yaml:"assets"`
+	StaticConfig  StaticConfig        `yaml:"static_config"`
+	Templates     TemplatesConfig     `yaml:"templates"`
+	Database      DatabaseConfig      `yaml:"database"`
+	ServerConfig  ServerConfig        `yaml:"server"`
+	LogConfig     LogConfig           `yaml:"log"`
+	Localization  LocalizationConfig  `yaml:"localization"`
+	Auth          AuthConfig          `yaml:"auth"`
+	Mail          MailConfig          `yaml:"mail"`
+	RBAC          RBACConfig          `yaml:"rbac"`
+	AccessControl AccessControlConfig `yaml:"accessControl"`
+	Session       SessionConfig       `yaml:"session"`
 }
 
 type TemplatesConfig struct {
@@ -34,17 +41,148 @@ type StaticConfig struct {
 
 // DatabaseConfig armazena as configurações do banco de dados.
 type DatabaseConfig struct {
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
-	Host     string `yaml:"host"`
-	Port     string `yaml:"port"`
-	Name     string `yaml:"name"`
+	User     string   `yaml:"user"`
+	Password Redacted `yaml:"password"`
+	Host     string   `yaml:"host"`
+	Port     string   `yaml:"port"`
+	Name     string   `yaml:"name"`
+
+	// Driver is the database/sql driver name, "postgres" when empty. Tests can
+	// register their own driver and select it here. DSN, when set, is handed to
+	// the driver as is instead of being built from the fields of this struct.
+	Driver string `yaml:"driver"`
+	DSN    string `yaml:"dsn"`
+
+	// Connection options for PostgreSQL. SSLMode defaults to "disable".
+	SSLMode         string        `yaml:"sslMode"`
+	SSLRootCert     string        `yaml:"sslRootCert"`
+	SSLCert         string        `yaml:"sslCert"`
+	SSLKey          string        `yaml:"sslKey"`
+	ConnectTimeout  time.Duration `yaml:"connectTimeout"`
+	ApplicationName string        `yaml:"applicationName"`
+	SearchPath      string        `yaml:"searchPath"`
+
+	// Pool settings for the shared connection pool kept by dbmanager.
+	MaxOpenConns    int           `yaml:"maxOpenConns"`
+	MaxIdleConns    int           `yaml:"maxIdleConns"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime"`
+
+	// Default timeouts applied to context-aware queries when the caller's
+	// context has no earlier deadline. Zero disables them.
+	QueryTimeout time.Duration `yaml:"queryTimeout"`
+	ExecTimeout  time.Duration `yaml:"execTimeout"`
+
+	// Queries running at least this long are logged with their arguments
+	// redacted. Zero disables the slow query log.
+	SlowQueryThreshold time.Duration `yaml:"slowQueryThreshold"`
+
+	// Read replicas as "host" or "host:port", sharing the credentials of the
+	// primary. Reads are spread over them round-robin; a replica that can't be
+	// reached is skipped for ReplicaEjectDuration (30s by default).
+	Replicas             []string      `yaml:"replicas"`
+	ReplicaEjectDuration time.Duration `yaml:"replicaEjectDuration"`
 }
 
 type ServerConfig struct {
 	Port string `yaml:"port"`
 }
 
+// AuthConfig armazena as configurações de autenticação.
+type AuthConfig struct {
+	// BcryptCost is the cost of new password hashes, bcrypt.DefaultCost when
+	// unset. Hashes with another cost are replaced on the next login.
+	BcryptCost int `yaml:"bcryptCost"`
+
+	Password      PasswordConfig      `yaml:"password"`
+	Lockout       LockoutConfig       `yaml:"lockout"`
+	PasswordReset PasswordResetConfig `yaml:"passwordReset"`
+	TwoFactor     TwoFactorConfig     `yaml:"twoFactor"`
+	WebAuthn      WebAuthnConfig      `yaml:"webAuthn"`
+}
+
+// PasswordResetConfig configures the password reset emails.
+type PasswordResetConfig struct {
+	// URL is the absolute address of the reset page linked from the email,
+	// e.g. https://apollo.example/reset-password. It is required by
+	// auth.RegisterPasswordResetRoutes.
+	URL string `yaml:"url"`
+	// TokenTTL is how long a reset link stays valid, one hour by default.
+	TokenTTL time.Duration `yaml:"tokenTTL"`
+}
+
+// TwoFactorConfig configures TOTP second factors.
+type TwoFactorConfig struct {
+	// Issuer names the application in authenticator apps, "Apollo" by default.
+	Issuer string `yaml:"issuer"`
+	// UserTypes lists the ApolloUser.UserType values that must complete a
+	// second factor before routes behind auth.RequireSecondFactor.
+	UserTypes []string `yaml:"userTypes"`
+	// Skew is how many 30 second steps around the current one are accepted,
+	// to allow for clock drift. 1 when unset.
+	Skew int `yaml:"skew"`
+	// VerifyPath is where users who still have to enter a code are sent,
+	// "/two-factor" by default.
+	VerifyPath string `yaml:"verifyPath"`
+	// EnrollPath is where users who must but haven't set up TOTP are sent,
+	// "/two-factor/setup" by default.
+	EnrollPath string `yaml:"enrollPath"`
+}
+
+// WebAuthnConfig configures passkey and security key logins.
+type WebAuthnConfig struct {
+	// RPID is the domain credentials are bound to, e.g. apollo.example.
+	RPID string `yaml:"rpID"`
+	// RPDisplayName is shown by the browser, "Apollo" by default.
+	RPDisplayName string `yaml:"rpDisplayName"`
+	// RPOrigins are the origins allowed to use the credentials, e.g.
+	// https://apollo.example.
+	RPOrigins []string `yaml:"rpOrigins"`
+}
+
+// MailConfig configures outgoing email.
+type MailConfig struct {
+	From string `yaml:"from"`
+	// Dir is where the file mailer writes messages, one .eml file each.
+	Dir string `yaml:"dir"`
+}
+
+// LockoutConfig controls login throttling. Zero values use the defaults
+// noted on each field.
+type LockoutConfig struct {
+	Disabled bool `yaml:"disabled"`
+	// Store keeps the failed attempts: "postgres" (default) or "memory" for a
+	// single instance.
+	Store string `yaml:"store"`
+	// Consecutive failures before an account (default 5) or an IP address
+	// (default 50) is locked.
+	MaxFailures   int `yaml:"maxFailures"`
+	MaxIPFailures int `yaml:"maxIPFailures"`
+	// LockoutDuration is how long a lock lasts (default 15m). Failures older
+	// than that are forgotten.
+	LockoutDuration time.Duration `yaml:"lockoutDuration"`
+	// Before the lock, each failure doubles the wait before the next attempt,
+	// starting at BaseDelay (default 1s) and capped at MaxDelay (default 30s).
+	BaseDelay time.Duration `yaml:"baseDelay"`
+	MaxDelay  time.Duration `yaml:"maxDelay"`
+}
+
+// PasswordConfig chooses how new password hashes are made.
+type PasswordConfig struct {
+	// Algorithm is the preferred hash algorithm, "argon2id" (default) or
+	// "bcrypt". Hashes made with another one are migrated on the next login.
+	Algorithm string       `yaml:"algorithm"`
+	Argon2    Argon2Config `yaml:"argon2"`
+}
+
+// Argon2Config holds the Argon2id parameters; zero values use the defaults
+// (64 MiB, 3 iterations, parallelism 2). Stored hashes using more than 1 GiB
+// or 64 iterations are rejected, so stay below.
+type Argon2Config struct {
+	MemoryKiB   uint32 `yaml:"memoryKiB"`
+	Iterations  uint32 `yaml:"iterations"`
+	Parallelism uint8  `yaml:"parallelism"`
+}
+
 type LoginRequest struct {
 	Email    string `JSON:"email"`
 	Password string `JSON:"password"`
@@ -59,73 +197,176 @@ type LogConfig struct {
 	LogToStdout bool   `yaml:"logToStdout"`
 }
 
-// AUTHBOSS INTERFACES
+// RBACConfig holds the role-based access control policy, either inline under
+// roles or in the YAML file at policyPath. Without either the built-in
+// policy of the rbac package applies.
+type RBACConfig struct {
+	PolicyPath string                `yaml:"policyPath"`
+	Roles      map[string]RoleConfig `yaml:"roles"`
+}
+
+// RoleConfig lists the permissions of a role, e.g. "records:read", and the
+// roles whose permissions it includes. "*" grants every permission and
+// "records:*" every permission on records.
+type RoleConfig struct {
+	Permissions []string `yaml:"permissions"`
+	Inherits    []string `yaml:"inherits"`
+}
+
+// AccessControlConfig configures the patient consent checks.
+type AccessControlConfig struct {
+	// CacheTTL is how long an allow or deny decision is reused, 30s by
+	// default. Negative disables the cache.
+	CacheTTL time.Duration `yaml:"cacheTTL"`
+}
+
+// SessionConfig configures the session cookies and how long sessions last.
+type SessionConfig struct {
+	SessionPolicyConfig `yaml:",inline"`
+
+	// UserTypes overrides the policy for some ApolloUser.UserType values,
+	// e.g. shorter sessions for admins. Unset fields keep the values above.
+	UserTypes map[string]SessionPolicyConfig `yaml:"userTypes"`
+
+	// ReauthPath is where users whose session expired are sent to log in
+	// again, "/login" by default.
+	ReauthPath string `yaml:"reauthPath"`
+
+	// Keys sign and encrypt the session cookies. The first pair is used for
+	// new cookies, the others only to read cookies made before a rotation.
+	Keys []SessionKeyConfig `yaml:"keys"`
+
+	// InsecureCookies lets the session and client state cookies be sent over
+	// plain HTTP, for development only. Browsers already treat localhost as
+	// secure.
+	InsecureCookies bool `yaml:"insecureCookies"`
+
+	// ReapInterval is how often expired and invalidated sessions are deleted
+	// by the sessionmanager.Reaper, 10 minutes by default.
+	ReapInterval time.Duration `yaml:"reapInterval"`
+	// ReapBatchSize is how many sessions each delete removes at most, 1000 by
+	// default, so the table isn't locked for long.
+	ReapBatchSize int `yaml:"reapBatchSize"`
+}
+
+// SessionPolicyConfig sets how long sessions last.
+type SessionPolicyConfig struct {
+	// IdleTimeout ends sessions unused for this long, 2 hours by default.
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	// AbsoluteLifetime ends sessions this long after login however active
+	// they are, 24 hours by default.
+	AbsoluteLifetime time.Duration `yaml:"absoluteLifetime"`
+	// RememberMeLifetime replaces both limits when the user asked to be
+	// remembered, 30 days by default. Negative disables remember me.
+	RememberMeLifetime time.Duration `yaml:"rememberMeLifetime"`
+}
+
+// SessionKeyConfig is one pair of base64 encoded session keys.
+type SessionKeyConfig struct {
+	// Authentication signs cookies, 32 or 64 bytes.
+	Authentication Redacted `yaml:"authentication"`
+	// Encryption encrypts cookies with AES, 16, 24 or 32 bytes. Cookies are
+	// only signed without it.
+	Encryption Redacted `yaml:"encryption"`
+}
+
+// ApolloUser is a row of the users table. It implements authboss's
+// AuthableUser, RecoverableUser and ConfirmableUser so it can be handed to
+// the register, recover and confirm modules; the email is the PID.
 type ApolloUser struct {
-	ID       int    `json:"id"`
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	UserType string `json:"userType"`
-	// Password string `json:"password"`
+	ID       int    `json:"id" db:"user_id"`
+	Name     string `json:"name" db:"name"`
+	Email    string `json:"email" db:"email"`
+	UserType string `json:"userType" db:"user_type"`
+	// Password is the password hash. It is never serialised, so a user stored
+	// in the session doesn't carry it.
+	Password string `json:"-" db:"password_hash"`
+
 	// needed for AuthBoss
-	// PID             string    `json:"pid"`
-	// RecoverSelector string    `json:"recoverselector"`
-	// RecoverVerifier string    `json:"recoververifier"`
-	// RecoverExpiry   time.Time `json:"recoveryexpiry"`
-	// authboss.User
-	// authboss.AuthableUser
-	// authboss.RecoverableUser
+	RecoverSelector string    `json:"-" db:"recover_selector"`
+	RecoverVerifier string    `json:"-" db:"recover_verifier"`
+	RecoverExpiry   time.Time `json:"-" db:"recover_expiry"`
+	ConfirmSelector string    `json:"-" db:"confirm_selector"`
+	ConfirmVerifier string    `json:"-" db:"confirm_verifier"`
+	Confirmed       bool      `json:"confirmed" db:"confirmed"`
 }
 
-//  AB - AUTHBOSS USER
+// AB - AUTHBOSS USER
 
-// // func (u *ApolloUser) GetPID() string {
-// // 	return u.PID
-// // }
+func (u *ApolloUser) GetPID() string {
+	return u.Email
+}
 
-// // func (u *ApolloUser) PutPID(pid string) {
-// // 	u.PID = pid
-// // }
+func (u *ApolloUser) PutPID(pid string) {
+	u.Email = pid
+}
 
-// // AB - AUTHABLE USER
+// AB - AUTHABLE USER
 
-// func (u *ApolloUser) GetPassword() (password string) {
-// 	return u.Password
-// }
+func (u *ApolloUser) GetPassword() (password string) {
+	return u.Password
+}
 
-// func (u *ApolloUser) PutPassword(password string) {
-// 	u.Password = password
-// }
+func (u *ApolloUser) PutPassword(password string) {
+	u.Password = password
+}
 
-// // AB - RECOVERABLE USER
+// AB - RECOVERABLE USER
 
-// func (u *ApolloUser) GetEmail() (email string) {
-// 	return u.Email
-// }
+func (u *ApolloUser) GetEmail() (email string) {
+	return u.Email
+}
 
-// func (u *ApolloUser) GetRecoverSelector() (selector string) {
-// 	return u.RecoverSelector
-// }
+func (u *ApolloUser) GetRecoverSelector() (selector string) {
+	return u.RecoverSelector
+}
 
-// func (u *ApolloUser) GetRecoverVerifier() (verifier string) {
-// 	return u.RecoverVerifier
-// }
+func (u *ApolloUser) GetRecoverVerifier() (verifier string) {
+	return u.RecoverVerifier
+}
 
-// func (u *ApolloUser) GetRecoverExpiry() (expiry time.Time) {
-// 	return u.RecoverExpiry
-// }
+func (u *ApolloUser) GetRecoverExpiry() (expiry time.Time) {
+	return u.RecoverExpiry
+}
 
-// func (u *ApolloUser) PutEmail(email string) {
-// 	u.Email = email
-// }
+func (u *ApolloUser) PutEmail(email string) {
+	u.Email = email
+}
 
-// func (u *ApolloUser) PutRecoverSelector(selector string) {
-// 	u.RecoverSelector = selector
-// }
+func (u *ApolloUser) PutRecoverSelector(selector string) {
+	u.RecoverSelector = selector
+}
+
+func (u *ApolloUser) PutRecoverVerifier(verifier string) {
+	u.RecoverVerifier = verifier
+}
+
+func (u *ApolloUser) PutRecoverExpiry(expiry time.Time) {
+	u.RecoverExpiry = expiry
+}
+
+// AB - CONFIRMABLE USER
+
+func (u *ApolloUser) GetConfirmed() (confirmed bool) {
+	return u.Confirmed
+}
 
-// func (u *ApolloUser) PutRecoverVerifier(verifier string) {
-// 	u.RecoverVerifier = verifier
-// }
+func (u *ApolloUser) GetConfirmSelector() (selector string) {
+	return u.ConfirmSelector
+}
+
+func (u *ApolloUser) GetConfirmVerifier() (verifier string) {
+	return u.ConfirmVerifier
+}
+
+func (u *ApolloUser) PutConfirmed(confirmed bool) {
+	u.Confirmed = confirmed
+}
+
+func (u *ApolloUser) PutConfirmSelector(selector string) {
+	u.ConfirmSelector = selector
+}
 
-// func (u *ApolloUser) PutRecoverExpiry(expiry time.Time) {
-// 	u.RecoverExpiry = expiry
-// }
+func (u *ApolloUser) PutConfirmVerifier(verifier string) {
+	u.ConfirmVerifier = verifier
+}
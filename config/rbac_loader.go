@@ -0,0 +1,29 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadRBACPolicy reads a policy file with the roles of an RBACConfig:
+//
+//	roles:
+//	  nurse:
+//	    permissions: [records:read]
+//	  doctor:
+//	    inherits: [nurse]
+//	    permissions: [records:write]
+func LoadRBACPolicy(filePath string) (map[string]RoleConfig, error) {
+	yamlFile, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RBAC policy file: %v", err)
+	}
+
+	var policy RBACConfig
+	if err := yaml.Unmarshal(yamlFile, &policy); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal RBAC policy: %v", err)
+	}
+	return policy.Roles, nil
+}
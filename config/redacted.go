@@ -0,0 +1,43 @@
+package config
+
+// redactedText replaces secrets wherever they are printed.
+const redactedText = "****"
+
+// Redacted holds a secret read from the configuration, such as a password. It
+// prints as "****" with fmt, in logs and when marshalled, so a config struct
+// can be dumped safely. Use Reveal to get the actual value.
+type Redacted string
+
+// Reveal returns the secret itself.
+func (r Redacted) Reveal() string {
+	return string(r)
+}
+
+// String implements fmt.Stringer. An empty secret prints as empty so that a
+// missing value is still noticeable.
+func (r Redacted) String() string {
+	if r == "" {
+		return ""
+	}
+	return redactedText
+}
+
+// GoString implements fmt.GoStringer for %#v.
+func (r Redacted) GoString() string {
+	return `"` + r.String() + `"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r Redacted) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + r.String() + `"`), nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (r Redacted) MarshalYAML() (interface{}, error) {
+	return r.String(), nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (r Redacted) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// DefaultSessionKey is the signing key the session cookies used before the
+// keys became configurable. It is public, so anyone can forge a cookie signed
+// with it; it is only accepted so old cookies survive the rotation away from
+// it.
+const DefaultSessionKey = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+const minAuthenticationKeyLength = 32
+
+// KeyPairs decodes Keys into the authentication and encryption key pairs taken
+// by sessions.NewCookieStore, newest first. Without keys it returns the
+// default key. LoadConfig refuses a configuration whose keys it can't decode.
+func (c SessionConfig) KeyPairs() ([][]byte, error) {
+	if len(c.Keys) == 0 {
+		return [][]byte{[]byte(DefaultSessionKey), nil}, nil
+	}
+
+	keyPairs := make([][]byte, 0, 2*len(c.Keys))
+	for i, key := range c.Keys {
+		var authentication []byte
+		if key.Authentication.Reveal() == DefaultSessionKey {
+			// The default key isn't base64, it is accepted as is.
+			authentication = []byte(DefaultSessionKey)
+		} else {
+			var err error
+			if authentication, err = decodeSessionKey(key.Authentication); err != nil {
+				return nil, fmt.Errorf("invalid authentication key of session key pair %d: %v", i+1, err)
+			}
+			if len(authentication) < minAuthenticationKeyLength {
+				return nil, fmt.Errorf("authentication key of session key pair %d is %d bytes, want at least %d", i+1, len(authentication), minAuthenticationKeyLength)
+			}
+		}
+
+		encryption, err := decodeSessionKey(key.Encryption)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key of session key pair %d: %v", i+1, err)
+		}
+		switch len(encryption) {
+		case 0:
+			encryption = nil
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("encryption key of session key pair %d is %d bytes, want 16, 24 or 32", i+1, len(encryption))
+		}
+
+		keyPairs = append(keyPairs, authentication, encryption)
+	}
+	return keyPairs, nil
+}
+
+func decodeSessionKey(key Redacted) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(key.Reveal())
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testKey(size int) Redacted {
+	return Redacted(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{byte(size)}, size)))
+}
+
+func TestSessionKeyPairs(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []SessionKeyConfig
+		want    [][]byte
+		wantErr string
+	}{
+		{
+			name: "no keys uses the default key",
+			want: [][]byte{[]byte(DefaultSessionKey), nil},
+		},
+		{
+			name: "signed and encrypted",
+			keys: []SessionKeyConfig{{Authentication: testKey(64), Encryption: testKey(32)}},
+			want: [][]byte{bytes.Repeat([]byte{64}, 64), bytes.Repeat([]byte{32}, 32)},
+		},
+		{
+			name: "signed only",
+			keys: []SessionKeyConfig{{Authentication: testKey(32)}},
+			want: [][]byte{bytes.Repeat([]byte{32}, 32), nil},
+		},
+		{
+			name: "rotation keeps the order",
+			keys: []SessionKeyConfig{
+				{Authentication: testKey(64), Encryption: testKey(16)},
+				{Authentication: testKey(32), Encryption: testKey(24)},
+			},
+			want: [][]byte{
+				bytes.Repeat([]byte{64}, 64), bytes.Repeat([]byte{16}, 16),
+				bytes.Repeat([]byte{32}, 32), bytes.Repeat([]byte{24}, 24),
+			},
+		},
+		{
+			name: "default key accepted as is while rotating away from it",
+			keys: []SessionKeyConfig{
+				{Authentication: testKey(64), Encryption: testKey(32)},
+				{Authentication: DefaultSessionKey},
+			},
+			want: [][]byte{
+				bytes.Repeat([]byte{64}, 64), bytes.Repeat([]byte{32}, 32),
+				[]byte(DefaultSessionKey), nil,
+			},
+		},
+		{
+			name:    "authentication key not base64",
+			keys:    []SessionKeyConfig{{Authentication: "not base64!"}},
+			wantErr: "invalid authentication key of session key pair 1",
+		},
+		{
+			name:    "authentication key too short",
+			keys:    []SessionKeyConfig{{Authentication: testKey(16)}},
+			wantErr: "authentication key of session key pair 1 is 16 bytes, want at least 32",
+		},
+		{
+			name:    "encryption key not base64",
+			keys:    []SessionKeyConfig{{Authentication: testKey(32), Encryption: "%%%"}},
+			wantErr: "invalid encryption key of session key pair 1",
+		},
+		{
+			name: "encryption key of the wrong size in an old pair",
+			keys: []SessionKeyConfig{
+				{Authentication: testKey(32)},
+				{Authentication: testKey(32), Encryption: testKey(20)},
+			},
+			wantErr: "encryption key of session key pair 2 is 20 bytes, want 16, 24 or 32",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SessionConfig{Keys: tt.keys}.KeyPairs()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("KeyPairs error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("KeyPairs: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KeyPairs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSessionKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []SessionKeyConfig
+	}{
+		{
+			name:  "one pair",
+			value: "auth:enc",
+			want:  []SessionKeyConfig{{Authentication: "auth", Encryption: "enc"}},
+		},
+		{
+			name:  "rotation with spaces and a signing only pair",
+			value: " new:newEnc , old ,",
+			want: []SessionKeyConfig{
+				{Authentication: "new", Encryption: "newEnc"},
+				{Authentication: "old"},
+			},
+		},
+		{
+			name:  "base64 padding kept",
+			value: "YWJjZA==:ZWZnaA==",
+			want:  []SessionKeyConfig{{Authentication: "YWJjZA==", Encryption: "ZWZnaA=="}},
+		},
+		{
+			name:  "only separators",
+			value: ", ,",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSessionKeys(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSessionKeys(%q) = %#v, want %#v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,16 +1,26 @@
 package cookiemanager
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/ApolloMedTech/Middleware/sessionmanager"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/sirupsen/logrus"
 	authboss "github.com/volatiletech/authboss/v3"
 )
 
 // MyCookieStore is a custom cookie state store that implements the authboss.ClientState interface.
 type MyCookieStore struct {
 	cookieName string
+	// codecs sign and encrypt the authboss client state.
+	codecs []securecookie.Codec
+	// maxAge is how long the client state cookie lasts, in seconds.
+	maxAge int
 }
 
 // Load loads the client state data for a given client token from the cookie.
@@ -36,51 +46,123 @@ func (m *MyCookieStore) Save(w http.ResponseWriter, r *http.Request, token strin
 	return nil
 }
 
-// NewMyCookieStore creates a new instance of MyCookieStore.
+// NewMyCookieStore creates a new instance of MyCookieStore, signing and
+// encrypting the authboss client state with the session keys from the config.
+// config.LoadConfig refuses invalid keys; set some other way, they leave the
+// store unable to write the client state.
 func NewMyCookieStore(cookieName string) *MyCookieStore {
+	keyPairs, err := sessionmanager.SessionKeyPairs(config.GetConfig().Session)
+	if err != nil {
+		logrus.Errorf("Error loading session keys, the client state can't be saved: %v", err)
+	}
+	return NewMyCookieStoreWithKeys(cookieName, keyPairs...)
+}
+
+// NewMyCookieStoreWithKeys creates a MyCookieStore protecting the client
+// state with the given authentication and encryption key pairs, as taken by
+// sessions.NewCookieStore. The state cookie lasts as long as a remembered
+// session.
+func NewMyCookieStoreWithKeys(cookieName string, keyPairs ...[]byte) *MyCookieStore {
+	maxAge := int(sessionmanager.PolicyFor(config.GetConfig().Session, "", true).AbsoluteLifetime / time.Second)
+
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(maxAge)
+		}
+	}
+
 	return &MyCookieStore{
 		cookieName: cookieName,
+		codecs:     codecs,
+		maxAge:     maxAge,
 	}
 }
 
-// ReadState implements authboss.ClientStateReadWriter.
+// CookieState is the authboss.ClientState read from the cookie.
+type CookieState map[string]string
+
+// Get implements authboss.ClientState.
+func (s CookieState) Get(key string) (string, bool) {
+	value, ok := s[key]
+	return value, ok
+}
+
+// ReadState implements authboss.ClientStateReadWriter. A missing cookie, or
+// one that can't be verified, reads as an empty state.
 func (m *MyCookieStore) ReadState(r *http.Request) (authboss.ClientState, error) {
-	var state authboss.ClientState
+	state := CookieState{}
+
 	cookie, err := r.Cookie(m.cookieName)
 	if err != nil {
-		// Return an empty state if the cookie is not found (no error for missing cookie)
-		if err == http.ErrNoCookie {
-			return state, nil
-		}
-		return nil, err
+		return state, nil
 	}
 
-	// Decode the cookie value (assuming it's JSON)
-	err = json.Unmarshal([]byte(cookie.Value), &state)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode client state cookie: %v", err)
+	if err := securecookie.DecodeMulti(m.cookieName, cookie.Value, &state, m.codecs...); err != nil {
+		logrus.Warnf("Ignoring invalid client state cookie: %v", err)
+		return CookieState{}, nil
 	}
 
 	return state, nil
 }
 
-// WriteState implements authboss.ClientStateReadWriter.
+// WriteState implements authboss.ClientStateReadWriter. It applies the
+// events to the state read by ReadState and writes the cookie, deleting it
+// once the state is empty.
 func (m *MyCookieStore) WriteState(w http.ResponseWriter, state authboss.ClientState, events []authboss.ClientStateEvent) error {
-	// Encode the state as JSON
-	stateJSON, err := json.Marshal(state)
-	if err != nil {
-		return fmt.Errorf("failed to encode client state as JSON: %v", err)
+	if len(events) == 0 {
+		return nil
 	}
 
-	// Create a new cookie with the encoded state
-	cookie := http.Cookie{
-		Name:  m.cookieName,
-		Value: string(stateJSON),
-		// Add other cookie options as needed
+	// Copied, the state read may still be used by the request.
+	values := CookieState{}
+	if current, ok := state.(CookieState); ok {
+		for key, value := range current {
+			values[key] = value
+		}
+	} else if state != nil {
+		return fmt.Errorf("client state %T was not read by MyCookieStore", state)
 	}
 
-	// Set the cookie in the response
-	http.SetCookie(w, &cookie)
+	for _, ev := range events {
+		switch ev.Kind {
+		case authboss.ClientStateEventPut:
+			values[ev.Key] = ev.Value
+		case authboss.ClientStateEventDel:
+			delete(values, ev.Key)
+		case authboss.ClientStateEventDelAll:
+			// Key lists the values to keep, comma separated.
+			whitelist := strings.Split(ev.Key, ",")
+			for key := range values {
+				if ev.Key == "" || !contains(whitelist, key) {
+					delete(values, key)
+				}
+			}
+		}
+	}
+
+	options := sessionmanager.CookieOptions(config.GetConfig().Session, m.maxAge)
 
+	var encoded string
+	if len(values) == 0 {
+		options.MaxAge = -1
+	} else {
+		var err error
+		encoded, err = securecookie.EncodeMulti(m.cookieName, map[string]string(values), m.codecs...)
+		if err != nil {
+			return fmt.Errorf("failed to encode client state cookie: %v", err)
+		}
+	}
+
+	http.SetCookie(w, sessions.NewCookie(m.cookieName, encoded, options))
 	return nil
 }
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package cookiemanager
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	authboss "github.com/volatiletech/authboss/v3"
+)
+
+const testCookieName = "AuthbossCookie"
+
+var testKeyPairs = [][]byte{bytes.Repeat([]byte("a"), 32), bytes.Repeat([]byte("e"), 32)}
+
+var testURL, _ = url.Parse("https://apollo.example/")
+
+// roundTrip reads the client state of a request carrying the jar's cookies,
+// writes events over it and stores the response cookies back in the jar. It
+// returns the state read.
+func roundTrip(t *testing.T, store *MyCookieStore, jar *cookiejar.Jar, events ...authboss.ClientStateEvent) CookieState {
+	r := httptest.NewRequest(http.MethodGet, testURL.String(), nil)
+	for _, cookie := range jar.Cookies(testURL) {
+		r.AddCookie(cookie)
+	}
+
+	state, err := store.ReadState(r)
+	if err != nil {
+		t.Fatalf("ReadState: %v", err)
+	}
+	read := CookieState{}
+	for key, value := range state.(CookieState) {
+		read[key] = value
+	}
+
+	w := httptest.NewRecorder()
+	if err := store.WriteState(w, state, events); err != nil {
+		t.Fatalf("WriteState: %v", err)
+	}
+	jar.SetCookies(testURL, w.Result().Cookies())
+	return read
+}
+
+func put(key, value string) authboss.ClientStateEvent {
+	return authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: key, Value: value}
+}
+
+func del(key string) authboss.ClientStateEvent {
+	return authboss.ClientStateEvent{Kind: authboss.ClientStateEventDel, Key: key}
+}
+
+// delAll deletes every value but those of whitelist, comma separated, as
+// authboss encodes it.
+func delAll(whitelist string) authboss.ClientStateEvent {
+	return authboss.ClientStateEvent{Kind: authboss.ClientStateEventDelAll, Key: whitelist}
+}
+
+func TestCookieStateRoundTrip(t *testing.T) {
+	store := NewMyCookieStoreWithKeys(testCookieName, testKeyPairs...)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		want   CookieState
+		events []authboss.ClientStateEvent
+	}{
+		{name: "put", want: CookieState{}, events: []authboss.ClientStateEvent{put("a", "1"), put("b", "2"), put("rm", "3"), put("other", "4")}},
+		{name: "put over and del", want: CookieState{"a": "1", "b": "2", "rm": "3", "other": "4"}, events: []authboss.ClientStateEvent{put("a", "5"), del("b")}},
+		{name: "no events keep the cookie", want: CookieState{"a": "5", "rm": "3", "other": "4"}},
+		{name: "del all but the whitelist", want: CookieState{"a": "5", "rm": "3", "other": "4"}, events: []authboss.ClientStateEvent{delAll("rm,a")}},
+		{name: "del all", want: CookieState{"a": "5", "rm": "3"}, events: []authboss.ClientStateEvent{delAll("")}},
+		{name: "cookie deleted once empty", want: CookieState{}},
+	}
+
+	for _, step := range steps {
+		if got := roundTrip(t, store, jar, step.events...); !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("%s: read %v, want %v", step.name, got, step.want)
+		}
+	}
+	if cookies := jar.Cookies(testURL); len(cookies) != 0 {
+		t.Errorf("empty state left cookies %v", cookies)
+	}
+}
+
+func TestCookieStateCookieOptions(t *testing.T) {
+	store := NewMyCookieStoreWithKeys(testCookieName, testKeyPairs...)
+
+	w := httptest.NewRecorder()
+	if err := store.WriteState(w, CookieState{}, []authboss.ClientStateEvent{put("a", "1")}); err != nil {
+		t.Fatalf("WriteState: %v", err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("WriteState set %d cookies, want 1", len(cookies))
+	}
+	cookie := cookies[0]
+	if cookie.Name != testCookieName || cookie.Path != "/" || !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge <= 0 {
+		t.Errorf("cookie %+v, want %s on / with HttpOnly, Secure, SameSite=Lax and a MaxAge", cookie, testCookieName)
+	}
+}
+
+func TestCookieStateInvalidCookie(t *testing.T) {
+	// A valid cookie of keys since removed.
+	old := NewMyCookieStoreWithKeys(testCookieName, bytes.Repeat([]byte("o"), 32), nil)
+	oldJar, _ := cookiejar.New(nil)
+	roundTrip(t, old, oldJar, put("a", "1"))
+	oldValue := oldJar.Cookies(testURL)[0].Value
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "garbage", value: "garbage"},
+		{name: "signed with a removed key", value: oldValue},
+		{name: "of another cookie name", value: func() string {
+			other := NewMyCookieStoreWithKeys("Other", testKeyPairs...)
+			jar, _ := cookiejar.New(nil)
+			roundTrip(t, other, jar, put("a", "1"))
+			return jar.Cookies(testURL)[0].Value
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMyCookieStoreWithKeys(testCookieName, testKeyPairs...)
+			jar, err := cookiejar.New(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jar.SetCookies(testURL, []*http.Cookie{{Name: testCookieName, Value: tt.value}})
+
+			if got := roundTrip(t, store, jar, put("b", "2")); len(got) != 0 {
+				t.Errorf("invalid cookie read as %v, want an empty state", got)
+			}
+			if got := roundTrip(t, store, jar); !reflect.DeepEqual(got, CookieState{"b": "2"}) {
+				t.Errorf("read %v after replacing the invalid cookie, want b=2", got)
+			}
+		})
+	}
+}
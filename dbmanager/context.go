@@ -0,0 +1,88 @@
+package dbmanager
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestContext returns the context of the HTTP request behind c, which is
+// cancelled as soon as the client goes away. Queries run with it stop together
+// with the request.
+func RequestContext(c *gin.Context) context.Context {
+	if c == nil || c.Request == nil {
+		return context.Background()
+	}
+	return c.Request.Context()
+}
+
+// withTimeout derives a context bounded by timeout, unless timeout is disabled
+// or ctx already expires earlier.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= timeout {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Rows wraps *sql.Rows so the query timeout is released, and the query
+// recorded in the metrics, when the rows are closed.
+type Rows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+	count  int64
+	done   func(count int64, err error)
+}
+
+// Next prepares the next row for Scan, see sql.Rows.Next.
+func (r *Rows) Next() bool {
+	if r.Rows.Next() {
+		r.count++
+		return true
+	}
+	return false
+}
+
+// Close closes the rows and releases the query context.
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	if r.done != nil {
+		readErr := r.Rows.Err()
+		if readErr == nil {
+			readErr = err
+		}
+		r.done(r.count, readErr)
+		r.done = nil
+	}
+	r.cancel()
+	return err
+}
+
+// Row wraps *sql.Row so the query timeout is released, and the query recorded
+// in the metrics, once the row is scanned. Scan must always be called, even
+// when the result is not needed: like *sql.Row, an unscanned Row holds on to
+// its connection, and here its timer as well.
+type Row struct {
+	*sql.Row
+	cancel context.CancelFunc
+	done   func(err error)
+}
+
+// Scan copies the columns of the row into dest and releases the query context.
+func (r *Row) Scan(dest ...interface{}) error {
+	defer r.cancel()
+
+	err := r.Row.Scan(dest...)
+	if r.done != nil {
+		r.done(err)
+	}
+	return err
+}
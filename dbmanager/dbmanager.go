@@ -1,10 +1,15 @@
 package dbmanager
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"regexp"
+	"sync"
+	"time"
 
 	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/google/uuid"
 	_ "github.com/lib/pq" // PostgresSQL driver
 	"github.com/sirupsen/logrus"
 )
@@ -12,72 +17,237 @@ import (
 // DBManager holds the database connection pool.
 type DBManager struct {
 	DB *sql.DB
+
+	// replicas receive the reads that don't need the primary; nil when no
+	// replica is configured.
+	replicas *replicaSet
 }
 
-// NewDBManager creates a new DBManager.
+// The pools are shared by the whole process and only opened on first use.
+var (
+	pool     *sql.DB
+	replicas *replicaSet
+	poolMu   sync.Mutex
+)
+
+// NewDBManager returns a DBManager backed by the shared connection pool,
+// opening the pool on the first call.
 func NewDBManager() (*DBManager, error) {
+	db, readers, err := getPool()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DBManager{DB: db, replicas: readers}, nil
+}
+
+// SetDB replaces the shared pool with a pre-built *sql.DB, mainly so tests can
+// inject their own connection. The caller keeps ownership of any previous pool.
+// The read replicas are closed along with their ejections, so every read goes
+// to db until SetReplicas is called.
+func SetDB(db *sql.DB) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	pool = db
+	if replicas != nil {
+		replicas.close()
+		replicas = nil
+	}
+}
+
+// SetReplicas replaces the shared read replicas with pre-built pools, mainly
+// for tests. Calling it without arguments sends every read to the primary.
+func SetReplicas(dbs ...*sql.DB) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	replicas = newReplicaSet(config.GetConfig().Database.ReplicaEjectDuration)
+	for i, db := range dbs {
+		replicas.add(fmt.Sprintf("replica-%d", i), db)
+	}
+	if len(dbs) == 0 {
+		replicas = nil
+	}
+}
+
+// ClosePool closes the shared pool and read replicas. It is meant to be called
+// once on shutdown; the next NewDBManager call opens a fresh pool.
+func ClosePool() error {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	if replicas != nil {
+		replicas.close()
+		replicas = nil
+	}
+
+	if pool == nil {
+		return nil
+	}
+
+	err := pool.Close()
+	pool = nil
+	if err != nil {
+		logrus.Errorf("Error closing database connection pool: %v", err)
+		return fmt.Errorf("error closing database connection pool: %v", err)
+	}
+	return nil
+}
+
+func getPool() (*sql.DB, *replicaSet, error) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	if pool != nil {
+		return pool, replicas, nil
+	}
+
 	cfg := config.GetConfig().Database
-	connStr := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%s sslmode=disable",
-		cfg.User, cfg.Password, cfg.Name, cfg.Host, cfg.Port)
-	logrus.Debug("Conntection string: ", connStr)
-	db, err := sql.Open("postgres", connStr)
+	db, err := openDB(cfg)
 	if err != nil {
-		logrus.Errorf("Error opening database: %v", err)
-		return nil, fmt.Errorf("error opening database: %v", err)
+		return nil, nil, err
+	}
+
+	pool = db
+	if replicas == nil {
+		replicas = openReplicas(cfg)
+	}
+	return pool, replicas, nil
+}
+
+func openDB(cfg config.DatabaseConfig) (*sql.DB, error) {
+	db, err := openPool(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	err = db.Ping()
 	if err != nil {
+		db.Close()
 		logrus.Errorf("Error connecting to database: %v", err)
 		return nil, fmt.Errorf("error connecting to database: %v", err)
 	}
 
-	return &DBManager{DB: db}, nil
+	return db, nil
 }
 
-// Insert executes an insert query and returns the ID of the last inserted row.
-func (manager *DBManager) Insert(query string, args ...interface{}) (int64, error) {
-	result, err := manager.DB.Exec(query, args...)
+// openPool opens a pool for cfg without connecting yet.
+func openPool(cfg config.DatabaseConfig) (*sql.DB, error) {
+	logrus.Debugf("Connecting to %s database %q on %s", driverName(cfg), cfg.Name, cfg.Host)
+	db, err := sql.Open(driverName(cfg), BuildDSN(cfg))
 	if err != nil {
-		logrus.Errorf("Error executing insert query '%s': %v", query, err)
-		return 0, fmt.Errorf("error executing insert query '%s': %v", query, err)
+		logrus.Errorf("Error opening database: %v", err)
+		return nil, fmt.Errorf("error opening database: %v", err)
 	}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		logrus.Errorf("Error getting last insert ID for query '%s': %v", query, err)
-		return 0, fmt.Errorf("error getting last insert ID for query '%s': %v", query, err)
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 	}
 
+	return db, nil
+}
+
+// Insert executes an insert query and returns the ID of the inserted row. The
+// query must end with a RETURNING clause naming the key column, see InsertReturning.
+func (manager *DBManager) Insert(query string, args ...interface{}) (int64, error) {
+	return manager.InsertContext(context.Background(), query, args...)
+}
+
+// InsertContext is like Insert but runs the query with ctx, bounded by the
+// configured exec timeout.
+func (manager *DBManager) InsertContext(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	return manager.InsertReturning(ctx, query, args...)
+}
+
+// InsertReturning executes an insert query ending in a RETURNING clause, e.g.
+// "INSERT INTO users (email) VALUES ($1) RETURNING user_id", and returns the
+// generated int64 key. lib/pq does not support sql.Result.LastInsertId, so the
+// key has to be read back as a row.
+func (manager *DBManager) InsertReturning(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	var id int64
+	if err := manager.insertReturning(ctx, &id, query, args...); err != nil {
+		return 0, err
+	}
 	return id, nil
 }
 
-// Update executes an update query and returns the number of affected rows.
-func (manager *DBManager) Update(query string, args ...interface{}) (int64, error) {
-	result, err := manager.DB.Exec(query, args...)
-	if err != nil {
-		logrus.Errorf("Error executing update query '%s': %v", query, err)
-		return 0, fmt.Errorf("error executing update query '%s': %v", query, err)
+// InsertReturningUUID is like InsertReturning for tables keyed by a UUID.
+func (manager *DBManager) InsertReturningUUID(ctx context.Context, query string, args ...interface{}) (uuid.UUID, error) {
+	var id uuid.UUID
+	if err := manager.insertReturning(ctx, &id, query, args...); err != nil {
+		return uuid.Nil, err
 	}
+	return id, nil
+}
 
-	rowsAffected, err := result.RowsAffected()
+var returningClause = regexp.MustCompile(`(?i)\bRETURNING\b`)
+
+// insertReturning runs query and scans the returned key into dest.
+func (manager *DBManager) insertReturning(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	// Refuse the query up front rather than writing the row and failing afterwards.
+	if !returningClause.MatchString(query) {
+		logrus.Errorf("Insert query '%s' has no RETURNING clause", query)
+		return fmt.Errorf("insert query '%s' has no RETURNING clause", query)
+	}
+
+	ctx, cancel := withTimeout(ctx, config.GetConfig().Database.ExecTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := manager.DB.QueryRowContext(ctx, query, args...).Scan(dest)
+	observe("insert", query, args, start, 1, err)
 	if err != nil {
-		logrus.Errorf("Error getting rows affected for query '%s': %v", query, err)
-		return 0, fmt.Errorf("error getting rows affected for query '%s': %v", query, err)
+		logrus.Errorf("Error executing insert query '%s': %v", query, err)
+		return fmt.Errorf("error executing insert query '%s': %v", query, err)
 	}
 
-	return rowsAffected, nil
+	return nil
+}
+
+// Update executes an update query and returns the number of affected rows.
+func (manager *DBManager) Update(query string, args ...interface{}) (int64, error) {
+	return manager.UpdateContext(context.Background(), query, args...)
+}
+
+// UpdateContext is like Update but runs the query with ctx, bounded by the
+// configured exec timeout.
+func (manager *DBManager) UpdateContext(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	return manager.execContext(ctx, "update", query, args...)
 }
 
 // Delete executes a delete query and returns the number of affected rows.
 func (manager *DBManager) Delete(query string, args ...interface{}) (int64, error) {
-	result, err := manager.DB.Exec(query, args...)
+	return manager.DeleteContext(context.Background(), query, args...)
+}
+
+// DeleteContext is like Delete but runs the query with ctx, bounded by the
+// configured exec timeout.
+func (manager *DBManager) DeleteContext(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	return manager.execContext(ctx, "delete", query, args...)
+}
+
+// execContext executes a statement and returns the number of affected rows.
+func (manager *DBManager) execContext(ctx context.Context, kind, query string, args ...interface{}) (int64, error) {
+	ctx, cancel := withTimeout(ctx, config.GetConfig().Database.ExecTimeout)
+	defer cancel()
+
+	start := time.Now()
+	result, err := manager.DB.ExecContext(ctx, query, args...)
 	if err != nil {
-		logrus.Errorf("Error executing delete query '%s': %v", query, err)
-		return 0, fmt.Errorf("error executing delete query '%s': %v", query, err)
+		observe(kind, query, args, start, 0, err)
+		logrus.Errorf("Error executing %s query '%s': %v", kind, query, err)
+		return 0, fmt.Errorf("error executing %s query '%s': %v", kind, query, err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
+	observe(kind, query, args, start, rowsAffected, err)
 	if err != nil {
 		logrus.Errorf("Error getting rows affected for query '%s': %v", query, err)
 		return 0, fmt.Errorf("error getting rows affected for query '%s': %v", query, err)
@@ -86,19 +256,71 @@ func (manager *DBManager) Delete(query string, args ...interface{}) (int64, erro
 	return rowsAffected, nil
 }
 
-// Select executes a select query and returns the rows.
-func (manager *DBManager) Select(query string, args ...interface{}) (*sql.Rows, error) {
-	rows, err := manager.DB.Query(query, args...)
+// Select executes a select query and returns the rows. Reads go to a replica
+// when one is configured, see ReadYourWrites. The query is recorded in the
+// metrics when the rows are closed, so close them.
+func (manager *DBManager) Select(query string, args ...interface{}) (*Rows, error) {
+	start := time.Now()
+	rows, err := manager.queryRead(context.Background(), query, args...)
+	if err != nil {
+		observe("select", query, args, start, 0, err)
+		logrus.Errorf("Error executing select query '%s': %v", query, err)
+		return nil, fmt.Errorf("error executing select query '%s': %v", query, err)
+	}
+	// No timeout to release, unlike SelectContext.
+	return &Rows{Rows: rows, cancel: func() {}, done: func(count int64, err error) {
+		observe("select", query, args, start, count, err)
+	}}, nil
+}
+
+// SelectContext is like Select but runs the query with ctx, bounded by the
+// configured query timeout. The timeout covers reading the rows, and is
+// released when the returned rows are closed.
+func (manager *DBManager) SelectContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	ctx, cancel := withTimeout(ctx, config.GetConfig().Database.QueryTimeout)
+
+	start := time.Now()
+	rows, err := manager.queryRead(ctx, query, args...)
 	if err != nil {
+		cancel()
+		observe("select", query, args, start, 0, err)
 		logrus.Errorf("Error executing select query '%s': %v", query, err)
 		return nil, fmt.Errorf("error executing select query '%s': %v", query, err)
 	}
-	return rows, nil
+	return &Rows{Rows: rows, cancel: cancel, done: func(count int64, err error) {
+		observe("select", query, args, start, count, err)
+	}}, nil
+}
+
+// SelectRowContext executes a query expected to return at most one row. Errors
+// are deferred until the row is scanned, and the row must be scanned, see Row.
+func (manager *DBManager) SelectRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	ctx, cancel := withTimeout(ctx, config.GetConfig().Database.QueryTimeout)
+
+	db, r := manager.reader(ctx, query)
+
+	start := time.Now()
+	return &Row{Row: db.QueryRowContext(ctx, query, args...), cancel: cancel, done: func(err error) {
+		rows := int64(0)
+		if err == nil {
+			rows = 1
+		}
+		observe("select", query, args, start, rows, err)
+		if r != nil && isConnectionError(err) {
+			manager.replicas.eject(r, err)
+		}
+	}}
 }
 
 // BeginTransaction starts a new database transaction.
 func (manager *DBManager) BeginTransaction() (*sql.Tx, error) {
-	tx, err := manager.DB.Begin()
+	return manager.BeginTransactionContext(context.Background(), nil)
+}
+
+// BeginTransactionContext starts a new database transaction bound to ctx. The
+// transaction is rolled back if ctx is cancelled before it is committed.
+func (manager *DBManager) BeginTransactionContext(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	tx, err := manager.DB.BeginTx(ctx, opts)
 	if err != nil {
 		logrus.Errorf("Error beginning transaction: %v", err)
 		return nil, fmt.Errorf("error beginning transaction: %v", err)
@@ -106,83 +328,8 @@ func (manager *DBManager) BeginTransaction() (*sql.Tx, error) {
 	return tx, nil
 }
 
-// Close closes the database connection.
+// Close is kept for existing callers and does nothing: the connection pool is
+// shared by every DBManager and is closed once with ClosePool.
 func (manager *DBManager) Close() error {
-	if err := manager.DB.Close(); err != nil {
-		logrus.Errorf("Error closing database connection: %v", err)
-	}
 	return nil
 }
-
-// func (db *DBManager) Load(ctx context.Context, key string) (authboss.User, error) {
-
-// 	// Use ConnectDB to establish a database connection
-// 	dbManager, err := NewDBManager()
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	defer dbManager.DB.Close()
-
-// 	row := dbManager.DB.QueryRow("SELECT user_id, email FROM users WHERE email = $1;", key)
-
-// 	var user config.ApolloUser
-// 	// Scan the retrieved row into the User struct
-// 	err = row.Scan(&user.ID, &user.Email)
-// 	if err != nil {
-// 		if err == sql.ErrNoRows {
-// 			return nil, authboss.ErrUserNotFound // User not found
-// 		}
-// 		return nil, err // Other error while scanning
-// 	}
-
-// 	// Return the user object retrieved from the database
-// 	return &user, nil
-// }
-
-// func (*DBManager) Save(ctx context.Context, user authboss.User) error {
-
-// 	// Use ConnectDB to establish a database connection
-// 	dbManager, err := NewDBManager()
-// 	if err != nil {
-// 		return err
-// 	}
-// 	defer dbManager.DB.Close()
-
-// 	// Prepare SQL query for user insertion
-// 	_, err = dbManager.DB.Exec("INSERT INTO users (email, password_hash) VALUES ($1, $2);",
-// 		user.(*config.ApolloUser).Email, user.(*config.ApolloUser).Password)
-// 	if err != nil {
-// 		return err
-// 	}
-
-// 	// If the insertion was successful, return nil indicating no error
-// 	return nil
-// }
-
-// // AB - RECOVERING SERVER STORER
-// func (*DBManager) LoadByRecoverSelector(ctx context.Context, selector string) (authboss.RecoverableUser, error) {
-// 	// Use ConnectDB to establish a database connection
-// 	dbManager, err := NewDBManager()
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	defer dbManager.DB.Close()
-
-// 	// Prepare SQL query to fetch user data based on selector
-// 	row := dbManager.DB.QueryRow("SELECT id, email, recoverselector, recoververifier, recoveryexpiry FROM users WHERE recoverselector = $1;", selector)
-
-// 	var user config.ApolloUser
-// 	// Scan the retrieved row into the User struct
-// 	err = row.Scan(&user.ID, &user.Email, &user.RecoverSelector, &user.RecoverVerifier, &user.RecoverExpiry)
-// 	if err != nil {
-// 		if err == sql.ErrNoRows {
-// 			return nil, authboss.ErrUserNotFound // User not found
-// 		}
-// 		return nil, err // Other error while scanning
-// 	}
-
-// 	// Return the user object retrieved from the database
-// 	return &user, nil
-// }
@@ -0,0 +1,189 @@
+package dbmanager
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/ApolloMedTech/Middleware/config"
+)
+
+// testDriverName is registered for the tests opening the pool from config.
+const testDriverName = "dbmanager-test"
+
+func init() {
+	sql.Register(testDriverName, &recordingDriver{})
+}
+
+// recordingDriver is a database/sql driver that records the statements it
+// is given, reports one affected row for each and returns no rows.
+type recordingDriver struct {
+	mu      sync.Mutex
+	queries []string
+}
+
+func (d *recordingDriver) Open(string) (driver.Conn, error) {
+	return &recordingConn{driver: d}, nil
+}
+
+func (d *recordingDriver) Connect(context.Context) (driver.Conn, error) {
+	return d.Open("")
+}
+
+func (d *recordingDriver) Driver() driver.Driver {
+	return d
+}
+
+func (d *recordingDriver) record(query string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queries = append(d.queries, query)
+}
+
+func (d *recordingDriver) recorded() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.queries...)
+}
+
+type recordingConn struct {
+	driver *recordingDriver
+}
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *recordingConn) Commit() error {
+	return nil
+}
+
+func (c *recordingConn) Rollback() error {
+	return nil
+}
+
+func (c *recordingConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.driver.record(query)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *recordingConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.driver.record(query)
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string {
+	return nil
+}
+
+func (emptyRows) Close() error {
+	return nil
+}
+
+func (emptyRows) Next([]driver.Value) error {
+	return io.EOF
+}
+
+func TestSetDB(t *testing.T) {
+	injected := &recordingDriver{}
+	replaced := &recordingDriver{}
+
+	tests := []struct {
+		name string
+		// setup installs the pools and returns the driver the manager must use.
+		setup func(t *testing.T) *recordingDriver
+	}{
+		{
+			name: "injected pool",
+			setup: func(t *testing.T) *recordingDriver {
+				SetDB(sql.OpenDB(injected))
+				return injected
+			},
+		},
+		{
+			name: "replaced pool stays open for its owner",
+			setup: func(t *testing.T) *recordingDriver {
+				previous := sql.OpenDB(injected)
+				t.Cleanup(func() { previous.Close() })
+				SetDB(previous)
+				SetDB(sql.OpenDB(replaced))
+				if err := previous.Ping(); err != nil {
+					t.Fatalf("previous pool was closed: %v", err)
+				}
+				return replaced
+			},
+		},
+		{
+			name: "replicas dropped with the replaced pool",
+			setup: func(t *testing.T) *recordingDriver {
+				SetDB(sql.OpenDB(injected))
+				SetReplicas(sql.OpenDB(&recordingDriver{}))
+				SetDB(sql.OpenDB(replaced))
+				return replaced
+			},
+		},
+		{
+			name: "pool opened from config after ClosePool",
+			setup: func(t *testing.T) *recordingDriver {
+				SetDB(sql.OpenDB(injected))
+				if err := ClosePool(); err != nil {
+					t.Fatalf("ClosePool: %v", err)
+				}
+				cfg := &config.GetConfig().Database
+				cfg.Driver, cfg.DSN = testDriverName, "test"
+				t.Cleanup(func() { cfg.Driver, cfg.DSN = "", "" })
+
+				db, err := sql.Open(testDriverName, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer db.Close()
+				return db.Driver().(*recordingDriver)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() { ClosePool() })
+			want := tt.setup(t)
+			before := len(want.recorded())
+
+			manager, err := NewDBManager()
+			if err != nil {
+				t.Fatalf("NewDBManager: %v", err)
+			}
+			rows, err := manager.UpdateContext(context.Background(), "UPDATE users SET name = $1;", "x")
+			if err != nil {
+				t.Fatalf("UpdateContext: %v", err)
+			}
+			if rows != 1 {
+				t.Errorf("UpdateContext affected %d rows, want 1", rows)
+			}
+
+			selected, err := manager.SelectContext(context.Background(), "SELECT name FROM users;")
+			if err != nil {
+				t.Fatalf("SelectContext: %v", err)
+			}
+			selected.Close()
+
+			queries := want.recorded()[before:]
+			if len(queries) != 2 || queries[0] != "UPDATE users SET name = $1;" || queries[1] != "SELECT name FROM users;" {
+				t.Errorf("statements run on the expected pool = %q, want the update and the select", queries)
+			}
+		})
+	}
+}
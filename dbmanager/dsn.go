@@ -0,0 +1,71 @@
+package dbmanager
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/ApolloMedTech/Middleware/config"
+)
+
+// defaultDriver is used when DatabaseConfig.Driver is empty.
+const defaultDriver = "postgres"
+
+func driverName(cfg config.DatabaseConfig) string {
+	if cfg.Driver == "" {
+		return defaultDriver
+	}
+	return cfg.Driver
+}
+
+// BuildDSN returns the connection string for cfg. An explicit cfg.DSN wins;
+// otherwise a postgres:// URL is built, so user names and passwords containing
+// spaces, quotes or other special characters are escaped correctly.
+func BuildDSN(cfg config.DatabaseConfig) string {
+	if cfg.DSN != "" {
+		return cfg.DSN
+	}
+
+	host := cfg.Host
+	if cfg.Port != "" {
+		host = net.JoinHostPort(cfg.Host, cfg.Port)
+	}
+
+	dsn := url.URL{
+		Scheme: "postgres",
+		Host:   host,
+		Path:   "/" + cfg.Name,
+	}
+	if cfg.Password != "" {
+		dsn.User = url.UserPassword(cfg.User, cfg.Password.Reveal())
+	} else if cfg.User != "" {
+		dsn.User = url.User(cfg.User)
+	}
+
+	sslMode := cfg.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	query := url.Values{}
+	query.Set("sslmode", sslMode)
+	setIfNotEmpty(query, "sslrootcert", cfg.SSLRootCert)
+	setIfNotEmpty(query, "sslcert", cfg.SSLCert)
+	setIfNotEmpty(query, "sslkey", cfg.SSLKey)
+	setIfNotEmpty(query, "application_name", cfg.ApplicationName)
+	// lib/pq sends parameters it does not know, such as search_path, to the
+	// server as run-time settings.
+	setIfNotEmpty(query, "search_path", cfg.SearchPath)
+	if seconds := int(cfg.ConnectTimeout.Seconds()); seconds > 0 {
+		query.Set("connect_timeout", strconv.Itoa(seconds))
+	}
+	dsn.RawQuery = query.Encode()
+
+	return dsn.String()
+}
+
+func setIfNotEmpty(values url.Values, key, value string) {
+	if value != "" {
+		values.Set(key, value)
+	}
+}
@@ -0,0 +1,96 @@
+package dbmanager
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HealthUnreachable is the Error of a HealthStatus or ReplicaStatus whose
+// database can't be reached. The driver's error is only logged, as it names
+// internal hosts and health endpoints are usually public.
+const HealthUnreachable = "unreachable"
+
+// HealthStatus describes the state of the database as seen by HealthCheck.
+type HealthStatus struct {
+	Healthy          bool            `json:"healthy"`
+	Latency          time.Duration   `json:"-"`
+	LatencyMS        float64         `json:"latencyMs"`
+	MigrationVersion int64           `json:"migrationVersion"`
+	Stats            sql.DBStats     `json:"pool"`
+	Error            string          `json:"error,omitempty"`
+	Replicas         []ReplicaStatus `json:"replicas,omitempty"`
+}
+
+// ReplicaStatus describes one read replica. Unhealthy replicas are ejected
+// from the read rotation but don't make the database unhealthy.
+type ReplicaStatus struct {
+	Host      string      `json:"-"`
+	Healthy   bool        `json:"healthy"`
+	LatencyMS float64     `json:"latencyMs"`
+	Stats     sql.DBStats `json:"pool"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// HealthCheck pings the database and reports the round trip latency, the
+// applied migration version and the connection pool statistics. The returned
+// error is set when the database can't be reached.
+func (manager *DBManager) HealthCheck(ctx context.Context) (HealthStatus, error) {
+	status := HealthStatus{Stats: manager.DB.Stats()}
+
+	start := time.Now()
+	err := manager.DB.PingContext(ctx)
+	status.Latency = time.Since(start)
+	status.LatencyMS = float64(status.Latency.Microseconds()) / 1000
+
+	if err != nil {
+		logrus.Errorf("Database health check failed: %v", err)
+		status.Error = HealthUnreachable
+		return status, fmt.Errorf("database health check failed: %v", err)
+	}
+	status.Healthy = true
+
+	// Databases set up before migrations were introduced have no
+	// schema_migrations table; they are still usable.
+	version, err := SchemaVersion(ctx, manager.DB)
+	if err != nil {
+		logrus.Debugf("Could not read schema version: %v", err)
+	}
+	status.MigrationVersion = version
+
+	status.Replicas = manager.checkReplicas(ctx)
+
+	return status, nil
+}
+
+// checkReplicas pings every replica, ejecting those that don't answer and
+// readmitting those that do.
+func (manager *DBManager) checkReplicas(ctx context.Context) []ReplicaStatus {
+	if manager.replicas == nil {
+		return nil
+	}
+
+	statuses := make([]ReplicaStatus, 0, len(manager.replicas.replicas))
+	for _, r := range manager.replicas.replicas {
+		status := ReplicaStatus{Host: r.host, Stats: r.db.Stats()}
+
+		start := time.Now()
+		err := r.db.PingContext(ctx)
+		status.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+
+		if err != nil {
+			// eject logs the error.
+			status.Error = HealthUnreachable
+			manager.replicas.eject(r, err)
+		} else {
+			// A replica answering again rejoins the rotation straight away.
+			r.ejectedUntil.Store(0)
+			status.Healthy = true
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
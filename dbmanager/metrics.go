@@ -0,0 +1,184 @@
+package dbmanager
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the query duration histogram.
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// queryCounter counts queries of one operation ending with one error class.
+type queryCounter struct {
+	operation  string
+	errorClass string
+}
+
+// durationHistogram accumulates the durations and row counts of one operation.
+type durationHistogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+	rows    uint64
+}
+
+// queryMetrics holds the counters and histograms of every query run through
+// DBManager since the process started.
+type queryMetrics struct {
+	mu         sync.Mutex
+	counters   map[queryCounter]uint64
+	histograms map[string]*durationHistogram
+}
+
+var metrics = &queryMetrics{
+	counters:   make(map[queryCounter]uint64),
+	histograms: make(map[string]*durationHistogram),
+}
+
+func (m *queryMetrics) record(operation, errorClass string, duration time.Duration, rows int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.counters[queryCounter{operation: operation, errorClass: errorClass}]++
+
+	histogram, ok := m.histograms[operation]
+	if !ok {
+		histogram = &durationHistogram{buckets: make([]uint64, len(durationBuckets))}
+		m.histograms[operation] = histogram
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			histogram.buckets[i]++
+		}
+	}
+	histogram.count++
+	histogram.sum += seconds
+	if rows > 0 {
+		histogram.rows += uint64(rows)
+	}
+}
+
+// observe records a finished query and logs it when it exceeded the
+// configured slow query threshold. Only the types of args are logged, never
+// their values.
+func observe(operation, query string, args []interface{}, start time.Time, rows int64, err error) {
+	duration := time.Since(start)
+	errorClass := ErrorClass(err)
+	metrics.record(operation, errorClass, duration, rows)
+
+	threshold := config.GetConfig().Database.SlowQueryThreshold
+	if threshold > 0 && duration >= threshold {
+		logrus.WithFields(logrus.Fields{
+			"operation":  operation,
+			"durationMs": duration.Milliseconds(),
+			"rows":       rows,
+			"errorClass": errorClass,
+		}).Warnf("Slow query '%s' with args %s", query, redactArgs(args))
+	}
+}
+
+// redactArgs describes query arguments by position and type only.
+func redactArgs(args []interface{}) string {
+	described := make([]string, len(args))
+	for i, arg := range args {
+		described[i] = fmt.Sprintf("$%d=%T", i+1, arg)
+	}
+	return "[" + strings.Join(described, " ") + "]"
+}
+
+// ErrorClass returns a short, low-cardinality name for err suitable as a
+// metric label: "none", "no_rows", "timeout", "canceled", "connection", the
+// PostgreSQL SQLSTATE class name (e.g. "integrity_constraint_violation") or
+// "other".
+func ErrorClass(err error) string {
+	var pqErr *pq.Error
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return "none"
+	case errors.Is(err, sql.ErrNoRows):
+		return "no_rows"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.As(err, &netErr):
+		return "connection"
+	case errors.As(err, &pqErr):
+		if name := pqErr.Code.Class().Name(); name != "" {
+			return name
+		}
+		return "sqlstate_" + string(pqErr.Code.Class())
+	default:
+		return "other"
+	}
+}
+
+// WriteMetrics writes the query counters and duration histograms in the
+// Prometheus text exposition format.
+func WriteMetrics(w io.Writer) error {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("# HELP apollo_db_queries_total Database queries run, by operation and error class.\n")
+	b.WriteString("# TYPE apollo_db_queries_total counter\n")
+	counters := make([]queryCounter, 0, len(metrics.counters))
+	for counter := range metrics.counters {
+		counters = append(counters, counter)
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].operation != counters[j].operation {
+			return counters[i].operation < counters[j].operation
+		}
+		return counters[i].errorClass < counters[j].errorClass
+	})
+	for _, counter := range counters {
+		fmt.Fprintf(&b, "apollo_db_queries_total{operation=%q,error_class=%q} %d\n",
+			counter.operation, counter.errorClass, metrics.counters[counter])
+	}
+
+	operations := make([]string, 0, len(metrics.histograms))
+	for operation := range metrics.histograms {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+
+	b.WriteString("# HELP apollo_db_rows_total Rows returned or affected by database queries, by operation.\n")
+	b.WriteString("# TYPE apollo_db_rows_total counter\n")
+	for _, operation := range operations {
+		fmt.Fprintf(&b, "apollo_db_rows_total{operation=%q} %d\n", operation, metrics.histograms[operation].rows)
+	}
+
+	b.WriteString("# HELP apollo_db_query_duration_seconds Database query duration, by operation.\n")
+	b.WriteString("# TYPE apollo_db_query_duration_seconds histogram\n")
+	for _, operation := range operations {
+		histogram := metrics.histograms[operation]
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(&b, "apollo_db_query_duration_seconds_bucket{operation=%q,le=\"%g\"} %d\n", operation, bound, histogram.buckets[i])
+		}
+		fmt.Fprintf(&b, "apollo_db_query_duration_seconds_bucket{operation=%q,le=\"+Inf\"} %d\n", operation, histogram.count)
+		fmt.Fprintf(&b, "apollo_db_query_duration_seconds_sum{operation=%q} %g\n", operation, histogram.sum)
+		fmt.Fprintf(&b, "apollo_db_query_duration_seconds_count{operation=%q} %d\n", operation, histogram.count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
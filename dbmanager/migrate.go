@@ -0,0 +1,273 @@
+package dbmanager
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/sirupsen/logrus"
+)
+
+// migrationFiles holds the schema shipped with the middleware. Files are named
+// <version>_<name>.up.sql and <version>_<name>.down.sql.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the key of the Postgres advisory lock held while
+// migrating, so that only one instance changes the schema at a time.
+const migrationLockID int64 = 7210430914285930501
+
+var migrationName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a single versioned schema change.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator applies migrations and records them in the schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+// NewMigrator creates a Migrator for db, opened with the named driver, using
+// the migrations in fsys. An empty driver means postgres, as in
+// DatabaseConfig, and a nil fsys uses the migrations embedded in this package.
+func NewMigrator(db *sql.DB, driver string, fsys fs.FS) (*Migrator, error) {
+	if fsys == nil {
+		sub, err := fs.Sub(migrationFiles, "migrations")
+		if err != nil {
+			return nil, fmt.Errorf("error opening embedded migrations: %v", err)
+		}
+		fsys = sub
+	}
+
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	if driver == "" {
+		driver = defaultDriver
+	}
+	return &Migrator{db: db, driver: driver, migrations: migrations}, nil
+}
+
+// Migrate applies every pending embedded migration to the shared pool. It is
+// meant to be called once at startup.
+func (manager *DBManager) Migrate(ctx context.Context) error {
+	migrator, err := NewMigrator(manager.DB, config.GetConfig().Database.Driver, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = migrator.Up(ctx)
+	return err
+}
+
+// LoadMigrations reads the migration files at the root of fsys, sorted by version.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %v", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %v", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %v", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Up applies every migration that has not been applied yet, in version order,
+// and returns how many were applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if applied[migration.Version] {
+				continue
+			}
+
+			logrus.Infof("Applying migration %d_%s", migration.Version, migration.Name)
+			err := runMigration(ctx, conn, migration.Up,
+				"INSERT INTO schema_migrations (version, name) VALUES ($1, $2);", migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("error applying migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns
+// how many were reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+			migration := m.migrations[i]
+			if !applied[migration.Version] {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down script", migration.Version, migration.Name)
+			}
+
+			logrus.Infof("Reverting migration %d_%s", migration.Version, migration.Name)
+			err := runMigration(ctx, conn, migration.Down,
+				"DELETE FROM schema_migrations WHERE version = $1;", migration.Version)
+			if err != nil {
+				return fmt.Errorf("error reverting migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// Version returns the highest applied migration version, or 0 when none is.
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	return SchemaVersion(ctx, m.db)
+}
+
+// SchemaVersion returns the highest migration version recorded in db, or 0
+// when no migration has been applied.
+func SchemaVersion(ctx context.Context, db Querier) (int64, error) {
+	version, err := QueryOne[sql.NullInt64](ctx, db, "SELECT MAX(version) FROM schema_migrations;")
+	if err != nil {
+		return 0, err
+	}
+	return version.Int64, nil
+}
+
+// withLock runs fn on a dedicated connection holding the migration advisory lock.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting connection for migrations: %v", err)
+	}
+	defer conn.Close()
+
+	// Advisory locks are PostgreSQL specific; other drivers are only used by
+	// tests, which run a single migrator.
+	if m.driver == defaultDriver {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1);", migrationLockID); err != nil {
+			return fmt.Errorf("error acquiring migration lock: %v", err)
+		}
+		defer func() {
+			if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1);", migrationLockID); err != nil {
+				logrus.Errorf("Error releasing migration lock: %v", err)
+			}
+		}()
+	}
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    BIGINT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %v", err)
+	}
+
+	return fn(conn)
+}
+
+// appliedVersions returns the set of versions recorded in schema_migrations.
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]bool, error) {
+	versions, err := QueryAll[int64](ctx, conn, "SELECT version FROM schema_migrations;")
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]bool, len(versions))
+	for _, version := range versions {
+		applied[version] = true
+	}
+	return applied, nil
+}
+
+// runMigration runs script and the bookkeeping statement in one transaction.
+func runMigration(ctx context.Context, conn *sql.Conn, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		rollback(tx)
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		rollback(tx)
+		return err
+	}
+
+	return tx.Commit()
+}
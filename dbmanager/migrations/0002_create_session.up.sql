@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS session (
+    session_id      UUID PRIMARY KEY,
+    user_id         INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    start_date      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expiration_date TIMESTAMPTZ NOT NULL,
+    active          BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE INDEX IF NOT EXISTS session_user_id_idx ON session (user_id);
+
+-- Databases created before the migrations have session.active as an integer,
+-- 1 for active and 0 for invalidated.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_schema = current_schema() AND table_name = 'session'
+               AND column_name = 'active' AND data_type <> 'boolean') THEN
+        ALTER TABLE session ALTER COLUMN active DROP DEFAULT;
+        ALTER TABLE session ALTER COLUMN active TYPE BOOLEAN USING COALESCE(active <> 0, FALSE);
+        ALTER TABLE session ALTER COLUMN active SET DEFAULT TRUE;
+        ALTER TABLE session ALTER COLUMN active SET NOT NULL;
+    END IF;
+END $$;
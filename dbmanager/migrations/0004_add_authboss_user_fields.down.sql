@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_users_confirm_selector;
+DROP INDEX IF EXISTS idx_users_recover_selector;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS confirmed,
+    DROP COLUMN IF EXISTS confirm_verifier,
+    DROP COLUMN IF EXISTS confirm_selector,
+    DROP COLUMN IF EXISTS recover_expiry,
+    DROP COLUMN IF EXISTS recover_verifier,
+    DROP COLUMN IF EXISTS recover_selector;
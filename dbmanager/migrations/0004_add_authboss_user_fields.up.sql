@@ -0,0 +1,13 @@
+ALTER TABLE users
+    ADD COLUMN recover_selector TEXT NOT NULL DEFAULT '',
+    ADD COLUMN recover_verifier TEXT NOT NULL DEFAULT '',
+    ADD COLUMN recover_expiry   TIMESTAMPTZ NOT NULL DEFAULT 'epoch',
+    ADD COLUMN confirm_selector TEXT NOT NULL DEFAULT '',
+    ADD COLUMN confirm_verifier TEXT NOT NULL DEFAULT '',
+    -- Accounts created before confirmation existed are taken as confirmed.
+    ADD COLUMN confirmed        BOOLEAN NOT NULL DEFAULT TRUE;
+
+ALTER TABLE users ALTER COLUMN confirmed SET DEFAULT FALSE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_recover_selector ON users (recover_selector) WHERE recover_selector <> '';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_confirm_selector ON users (confirm_selector) WHERE confirm_selector <> '';
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id        INTEGER PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+    secret         TEXT NOT NULL,
+    confirmed      BOOLEAN NOT NULL DEFAULT FALSE,
+    -- Last accepted 30 second step, so a code can't be used twice.
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    code_id   SERIAL PRIMARY KEY,
+    user_id   INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user_id ON user_recovery_codes (user_id);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    credential_id BYTEA PRIMARY KEY,
+    user_id       INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    -- The webauthn.Credential, with the public key and signature counter.
+    credential    JSONB NOT NULL,
+    name          TEXT NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials (user_id);
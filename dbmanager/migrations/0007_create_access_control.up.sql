@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS access_control (
+    access_id     UUID PRIMARY KEY,
+    numero_utente CHAR(9) NOT NULL,
+    personnel_id  INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    access_status TEXT NOT NULL DEFAULT 'pending',
+    start_date    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expiry_date   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_control_patient_personnel ON access_control (numero_utente, personnel_id);
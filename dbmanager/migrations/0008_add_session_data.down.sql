@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS session_expiration_date_idx;
+ALTER TABLE session DROP COLUMN IF EXISTS data;
+DELETE FROM session WHERE user_id IS NULL;
+ALTER TABLE session ALTER COLUMN user_id SET NOT NULL;
@@ -0,0 +1,6 @@
+-- Sessions of the database store hold their values server-side, and may
+-- belong to nobody until the user logs in.
+ALTER TABLE session ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE session ADD COLUMN IF NOT EXISTS data BYTEA NOT NULL DEFAULT ''::bytea;
+
+CREATE INDEX IF NOT EXISTS session_expiration_date_idx ON session (expiration_date);
@@ -0,0 +1,3 @@
+ALTER TABLE session DROP COLUMN IF EXISTS last_seen;
+ALTER TABLE session DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE session DROP COLUMN IF EXISTS ip;
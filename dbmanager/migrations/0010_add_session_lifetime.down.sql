@@ -0,0 +1,2 @@
+ALTER TABLE session DROP COLUMN IF EXISTS absolute_expiration;
+ALTER TABLE session DROP COLUMN IF EXISTS idle_timeout;
@@ -0,0 +1,6 @@
+-- expiration_date is when the session ends if it isn't used again: the
+-- earlier of last use plus idle_timeout and absolute_expiration.
+ALTER TABLE session ADD COLUMN IF NOT EXISTS idle_timeout INTERVAL NOT NULL DEFAULT interval '2 hours';
+ALTER TABLE session ADD COLUMN IF NOT EXISTS absolute_expiration TIMESTAMPTZ;
+UPDATE session SET absolute_expiration = expiration_date WHERE absolute_expiration IS NULL;
+ALTER TABLE session ALTER COLUMN absolute_expiration SET NOT NULL;
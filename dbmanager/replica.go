@@ -0,0 +1,172 @@
+package dbmanager
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultEjectDuration is how long a failing replica is skipped when
+// DatabaseConfig.ReplicaEjectDuration is not set.
+const defaultEjectDuration = 30 * time.Second
+
+type readYourWritesKey struct{}
+
+// ReadYourWrites returns a context whose reads go to the primary, for requests
+// that must see what they have just written. Replicas may lag behind.
+func ReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readYourWritesKey{}, true)
+}
+
+var readOnlyQuery = regexp.MustCompile(`(?is)^\s*SELECT\b`)
+var lockingClause = regexp.MustCompile(`(?i)\bFOR\s+(?:NO\s+KEY\s+)?(?:UPDATE|SHARE|KEY\s+SHARE)\b`)
+
+// readsFromPrimary reports whether query has to run on the primary: ctx asks
+// for it, or the statement writes or locks rows, e.g. an UPDATE ... RETURNING
+// read through QueryOne.
+func readsFromPrimary(ctx context.Context, query string) bool {
+	if primary, _ := ctx.Value(readYourWritesKey{}).(bool); primary {
+		return true
+	}
+	return !readOnlyQuery.MatchString(query) || lockingClause.MatchString(query)
+}
+
+// replica is a read-only pool which is skipped for a while after it fails.
+type replica struct {
+	host         string
+	db           *sql.DB
+	ejectedUntil atomic.Int64 // unix nanoseconds
+}
+
+func (r *replica) available(now time.Time) bool {
+	return now.UnixNano() >= r.ejectedUntil.Load()
+}
+
+// replicaSet hands out replicas round-robin, skipping the ejected ones.
+type replicaSet struct {
+	replicas      []*replica
+	next          atomic.Uint64
+	ejectDuration time.Duration
+}
+
+func newReplicaSet(ejectDuration time.Duration) *replicaSet {
+	if ejectDuration <= 0 {
+		ejectDuration = defaultEjectDuration
+	}
+	return &replicaSet{ejectDuration: ejectDuration}
+}
+
+func (s *replicaSet) add(host string, db *sql.DB) *replica {
+	r := &replica{host: host, db: db}
+	s.replicas = append(s.replicas, r)
+	return r
+}
+
+// pick returns the next available replica, or nil when all are ejected.
+func (s *replicaSet) pick() *replica {
+	if s == nil || len(s.replicas) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	start := s.next.Add(1)
+	for i := 0; i < len(s.replicas); i++ {
+		r := s.replicas[(start+uint64(i))%uint64(len(s.replicas))]
+		if r.available(now) {
+			return r
+		}
+	}
+	return nil
+}
+
+// eject takes r out of the rotation for the eject duration.
+func (s *replicaSet) eject(r *replica, err error) {
+	r.ejectedUntil.Store(time.Now().Add(s.ejectDuration).UnixNano())
+	logrus.Warnf("Ejecting database replica %s for %s: %v", r.host, s.ejectDuration, err)
+}
+
+func (s *replicaSet) close() {
+	for _, r := range s.replicas {
+		if err := r.db.Close(); err != nil {
+			logrus.Errorf("Error closing database replica %s: %v", r.host, err)
+		}
+	}
+}
+
+// openReplicas opens a pool for each replica host in cfg, sharing the
+// credentials and settings of the primary. Unreachable replicas start ejected
+// instead of failing startup.
+func openReplicas(cfg config.DatabaseConfig) *replicaSet {
+	if len(cfg.Replicas) == 0 {
+		return nil
+	}
+
+	set := newReplicaSet(cfg.ReplicaEjectDuration)
+	for _, address := range cfg.Replicas {
+		replicaCfg := cfg
+		replicaCfg.DSN = ""
+		replicaCfg.Host, replicaCfg.Port = address, cfg.Port
+		if host, port, err := net.SplitHostPort(address); err == nil {
+			replicaCfg.Host, replicaCfg.Port = host, port
+		}
+
+		db, err := openPool(replicaCfg)
+		if err != nil {
+			logrus.Errorf("Error opening database replica %s: %v", address, err)
+			continue
+		}
+
+		r := set.add(address, db)
+		if err := db.Ping(); err != nil {
+			set.eject(r, err)
+		}
+	}
+
+	if len(set.replicas) == 0 {
+		return nil
+	}
+	return set
+}
+
+// isConnectionError reports whether err means the server could not be reached,
+// as opposed to a problem with the query itself.
+func isConnectionError(err error) bool {
+	switch ErrorClass(err) {
+	case "connection", "connection_exception":
+		return true
+	}
+	return false
+}
+
+// queryRead runs a read query on a replica when one is available and ctx
+// allows it, falling back to the primary if the replica can't be reached.
+func (manager *DBManager) queryRead(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if !readsFromPrimary(ctx, query) {
+		if r := manager.replicas.pick(); r != nil {
+			rows, err := r.db.QueryContext(ctx, query, args...)
+			if err == nil || !isConnectionError(err) {
+				return rows, err
+			}
+			manager.replicas.eject(r, err)
+		}
+	}
+
+	return manager.DB.QueryContext(ctx, query, args...)
+}
+
+// reader returns the pool for a read whose errors only surface later, along
+// with the replica behind it, or nil for the primary.
+func (manager *DBManager) reader(ctx context.Context, query string) (*sql.DB, *replica) {
+	if !readsFromPrimary(ctx, query) {
+		if r := manager.replicas.pick(); r != nil {
+			return r.db, r
+		}
+	}
+	return manager.DB, nil
+}
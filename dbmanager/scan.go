@@ -0,0 +1,197 @@
+package dbmanager
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Querier runs queries returning rows. *DBManager satisfies it, as do
+// *sql.DB and *sql.Tx; use Tx.Querier inside WithTransaction.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// QueryContext runs a read query and returns the raw rows. Like SelectContext
+// it prefers a replica, but applies no default timeout; it mainly lets
+// DBManager be used as a Querier.
+func (manager *DBManager) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return manager.queryRead(ctx, query, args...)
+}
+
+// QueryOne runs query and scans its first row into a T. Structs are filled by
+// matching column names against `db` tags, any other T must be a single column.
+// It returns sql.ErrNoRows when the query has no rows.
+func QueryOne[T any](ctx context.Context, q Querier, query string, args ...interface{}) (T, error) {
+	var result T
+
+	err := queryRows(ctx, q, query, args, func(rows *sql.Rows, columns []string) (bool, error) {
+		if err := scanRow(rows, columns, &result); err != nil {
+			return false, err
+		}
+		return false, nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// QueryAll runs query and scans every row into a T, see QueryOne. It returns
+// an empty slice when the query has no rows.
+func QueryAll[T any](ctx context.Context, q Querier, query string, args ...interface{}) ([]T, error) {
+	results := []T{}
+
+	err := queryRows(ctx, q, query, args, func(rows *sql.Rows, columns []string) (bool, error) {
+		var item T
+		if err := scanRow(rows, columns, &item); err != nil {
+			return false, err
+		}
+		results = append(results, item)
+		return true, nil
+	})
+	if err == sql.ErrNoRows {
+		return results, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// queryRows runs a query bounded by the configured query timeout and calls each
+// for every row until it returns false. The rows are always closed, and
+// sql.ErrNoRows is returned when there was no row at all.
+func queryRows(ctx context.Context, q Querier, query string, args []interface{}, each func(rows *sql.Rows, columns []string) (bool, error)) error {
+	ctx, cancel := withTimeout(ctx, config.GetConfig().Database.QueryTimeout)
+	defer cancel()
+
+	start := time.Now()
+	count := int64(0)
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		observe("select", query, args, start, 0, err)
+		logrus.Errorf("Error executing select query '%s': %v", query, err)
+		return fmt.Errorf("error executing select query '%s': %w", query, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("error reading columns for query '%s': %w", query, err)
+	}
+
+	for rows.Next() {
+		count++
+		more, err := each(rows, columns)
+		if err != nil {
+			observe("select", query, args, start, count, err)
+			logrus.Errorf("Error scanning row for query '%s': %v", query, err)
+			return fmt.Errorf("error scanning row for query '%s': %w", query, err)
+		}
+		if !more {
+			break
+		}
+	}
+
+	err = rows.Err()
+	observe("select", query, args, start, count, err)
+	if err != nil {
+		logrus.Errorf("Error reading rows for query '%s': %v", query, err)
+		return fmt.Errorf("error reading rows for query '%s': %w", query, err)
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
+// scanRow scans the current row into dest, a pointer to a struct or scalar.
+func scanRow(rows *sql.Rows, columns []string, dest interface{}) error {
+	value := reflect.ValueOf(dest).Elem()
+
+	if !isStruct(value.Type()) {
+		if len(columns) != 1 {
+			return fmt.Errorf("cannot scan %d columns into %s", len(columns), value.Type())
+		}
+		return rows.Scan(dest)
+	}
+
+	fields := fieldsOf(value.Type())
+	targets := make([]interface{}, len(columns))
+	for i, column := range columns {
+		index, ok := fields[column]
+		if !ok {
+			return fmt.Errorf("column %q has no matching field in %s", column, value.Type())
+		}
+		targets[i] = value.FieldByIndex(index).Addr().Interface()
+	}
+
+	return rows.Scan(targets...)
+}
+
+// isStruct reports whether t should be filled field by field rather than
+// scanned as a single value.
+func isStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType && !reflect.PtrTo(t).Implements(scannerType)
+}
+
+var fieldCache sync.Map // reflect.Type -> map[string][]int
+
+// fieldsOf maps column names to field indexes for the struct type t. Columns
+// are named by the `db` tag, or the lower-cased field name without one.
+// Untagged embedded structs are flattened and `db:"-"` fields are skipped.
+func fieldsOf(t reflect.Type) map[string][]int {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.(map[string][]int)
+	}
+
+	fields := make(map[string][]int)
+	collectFields(t, nil, fields)
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+func collectFields(t reflect.Type, parent []int, fields map[string][]int) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+
+		index := append(append([]int{}, parent...), i)
+
+		if field.Anonymous && tag == "" && isStruct(field.Type) {
+			collectFields(field.Type, index, fields)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		name := tag
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		if _, exists := fields[name]; !exists {
+			fields[name] = index
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package dbmanager
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// serializationFailure is the SQLSTATE PostgreSQL reports when a serializable
+// or repeatable read transaction has to be retried.
+const serializationFailure = "40001"
+
+// TxOptions configures a transaction started by WithTransaction.
+type TxOptions struct {
+	// Isolation is the isolation level, sql.LevelDefault uses the server default.
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// MaxRetries is how many times the whole transaction is run again after a
+	// serialization failure. Zero disables retries.
+	MaxRetries int
+}
+
+// Tx is the transaction handed to WithTransaction callbacks. Its statements
+// are recorded in the query metrics, queries once their rows are closed or
+// their row is scanned.
+type Tx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row
+	// Querier returns the transaction for QueryOne and QueryAll, which record
+	// their queries themselves.
+	Querier() Querier
+	// WithSavepoint runs fn inside a savepoint of this transaction. If fn fails
+	// only the work done since the savepoint is undone and the error is returned.
+	// Savepoints can be nested.
+	WithSavepoint(ctx context.Context, fn func(tx Tx) error) error
+}
+
+// txn implements Tx on top of *sql.Tx.
+type txn struct {
+	*sql.Tx
+	savepoints int
+}
+
+// WithTransaction runs fn in a transaction which is committed when fn returns
+// nil and rolled back when it returns an error or panics. Serialization
+// failures are retried up to opts.MaxRetries times, so fn must be safe to run
+// more than once. opts may be nil.
+func (manager *DBManager) WithTransaction(ctx context.Context, opts *TxOptions, fn func(tx Tx) error) error {
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := manager.runTransaction(ctx, opts, fn)
+		if err == nil || !IsSerializationFailure(err) || attempt >= opts.MaxRetries {
+			return err
+		}
+
+		logrus.Warnf("Retrying transaction after serialization failure (attempt %d of %d): %v", attempt+1, opts.MaxRetries, err)
+
+		// Back off a little so the conflicting transaction can finish first.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * 10 * time.Millisecond):
+		}
+	}
+}
+
+// runTransaction runs fn once in its own transaction.
+func (manager *DBManager) runTransaction(ctx context.Context, opts *TxOptions, fn func(tx Tx) error) (err error) {
+	tx, err := manager.BeginTransactionContext(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollback(tx)
+			panic(p)
+		}
+	}()
+
+	if err = fn(&txn{Tx: tx}); err != nil {
+		rollback(tx)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		logrus.Errorf("Error committing transaction: %v", err)
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+func rollback(tx *sql.Tx) {
+	if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		logrus.Errorf("Error rolling back transaction: %v", err)
+	}
+}
+
+// ExecContext implements Tx, recording the statement in the query metrics.
+func (t *txn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := t.Tx.ExecContext(ctx, query, args...)
+
+	rows := int64(0)
+	if err == nil {
+		rows, _ = result.RowsAffected()
+	}
+	observe("exec", query, args, start, rows, err)
+
+	return result, err
+}
+
+// QueryContext implements Tx.
+func (t *txn) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	start := time.Now()
+	rows, err := t.Tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		observe("select", query, args, start, 0, err)
+		return nil, err
+	}
+	return &Rows{Rows: rows, cancel: func() {}, done: func(count int64, err error) {
+		observe("select", query, args, start, count, err)
+	}}, nil
+}
+
+// QueryRowContext implements Tx.
+func (t *txn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	start := time.Now()
+	return &Row{Row: t.Tx.QueryRowContext(ctx, query, args...), cancel: func() {}, done: func(err error) {
+		rows := int64(0)
+		if err == nil {
+			rows = 1
+		}
+		observe("select", query, args, start, rows, err)
+	}}
+}
+
+// Querier implements Tx.
+func (t *txn) Querier() Querier {
+	return t.Tx
+}
+
+// WithSavepoint implements Tx.
+func (t *txn) WithSavepoint(ctx context.Context, fn func(tx Tx) error) (err error) {
+	t.savepoints++
+	name := fmt.Sprintf("sp_%d", t.savepoints)
+
+	if _, err = t.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		logrus.Errorf("Error creating savepoint %s: %v", name, err)
+		return fmt.Errorf("error creating savepoint %s: %w", name, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			t.rollbackTo(ctx, name)
+			panic(p)
+		}
+	}()
+
+	if err = fn(t); err != nil {
+		t.rollbackTo(ctx, name)
+		return err
+	}
+
+	if _, err = t.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		logrus.Errorf("Error releasing savepoint %s: %v", name, err)
+		return fmt.Errorf("error releasing savepoint %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func (t *txn) rollbackTo(ctx context.Context, name string) {
+	if _, err := t.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); err != nil {
+		logrus.Errorf("Error rolling back to savepoint %s: %v", name, err)
+	}
+}
+
+// IsSerializationFailure reports whether err is a PostgreSQL serialization
+// failure (SQLSTATE 40001), meaning the transaction can be retried.
+func IsSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == serializationFailure
+}
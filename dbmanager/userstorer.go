@@ -0,0 +1,160 @@
+package dbmanager
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/sirupsen/logrus"
+	authboss "github.com/volatiletech/authboss/v3"
+)
+
+// defaultUserType is given to users created through authboss, whose register
+// module knows nothing about user types.
+const defaultUserType = "patient"
+
+const userColumns = "user_id, name, email, user_type, password_hash, " +
+	"recover_selector, recover_verifier, recover_expiry, confirm_selector, confirm_verifier, confirmed"
+
+var (
+	_ authboss.CreatingServerStorer   = (*UserStorer)(nil)
+	_ authboss.RecoveringServerStorer = (*UserStorer)(nil)
+	_ authboss.ConfirmingServerStorer = (*UserStorer)(nil)
+
+	_ authboss.AuthableUser    = (*config.ApolloUser)(nil)
+	_ authboss.RecoverableUser = (*config.ApolloUser)(nil)
+	_ authboss.ConfirmableUser = (*config.ApolloUser)(nil)
+)
+
+// UserStorer is the authboss ServerStorer for the users table, keyed by email.
+type UserStorer struct {
+	manager *DBManager
+}
+
+// NewUserStorer returns a UserStorer on the shared connection pool.
+func NewUserStorer() (*UserStorer, error) {
+	manager, err := NewDBManager()
+	if err != nil {
+		return nil, err
+	}
+	return &UserStorer{manager: manager}, nil
+}
+
+// Load implements authboss.ServerStorer.
+func (s *UserStorer) Load(ctx context.Context, key string) (authboss.User, error) {
+	user, err := s.loadBy(ctx, "email", key)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Save implements authboss.ServerStorer. It updates the user with the same
+// email, so the email itself can't be changed through it.
+func (s *UserStorer) Save(ctx context.Context, user authboss.User) error {
+	u, err := apolloUser(user)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.manager.UpdateContext(ctx, `UPDATE users SET name = $2, user_type = $3, password_hash = $4,
+		recover_selector = $5, recover_verifier = $6, recover_expiry = $7,
+		confirm_selector = $8, confirm_verifier = $9, confirmed = $10
+		WHERE email = $1;`,
+		u.Email, u.Name, u.UserType, u.Password,
+		u.RecoverSelector, u.RecoverVerifier, u.RecoverExpiry,
+		u.ConfirmSelector, u.ConfirmVerifier, u.Confirmed)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return authboss.ErrUserNotFound
+	}
+	return nil
+}
+
+// New implements authboss.CreatingServerStorer.
+func (s *UserStorer) New(ctx context.Context) authboss.User {
+	return &config.ApolloUser{}
+}
+
+// Create implements authboss.CreatingServerStorer. It returns
+// authboss.ErrUserFound when the email is already registered.
+func (s *UserStorer) Create(ctx context.Context, user authboss.User) error {
+	u, err := apolloUser(user)
+	if err != nil {
+		return err
+	}
+	if u.UserType == "" {
+		u.UserType = defaultUserType
+	}
+
+	ctx, cancel := withTimeout(ctx, config.GetConfig().Database.ExecTimeout)
+	defer cancel()
+
+	id, err := QueryOne[int](ctx, s.manager, `INSERT INTO users (name, email, user_type, password_hash,
+		recover_selector, recover_verifier, recover_expiry, confirm_selector, confirm_verifier, confirmed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (email) DO NOTHING
+		RETURNING user_id;`,
+		u.Name, u.Email, u.UserType, u.Password,
+		u.RecoverSelector, u.RecoverVerifier, u.RecoverExpiry,
+		u.ConfirmSelector, u.ConfirmVerifier, u.Confirmed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return authboss.ErrUserFound
+		}
+		logrus.Errorf("Error creating user: %v", err)
+		return fmt.Errorf("error creating user: %v", err)
+	}
+
+	u.ID = id
+	return nil
+}
+
+// LoadByRecoverSelector implements authboss.RecoveringServerStorer.
+func (s *UserStorer) LoadByRecoverSelector(ctx context.Context, selector string) (authboss.RecoverableUser, error) {
+	if selector == "" {
+		return nil, authboss.ErrUserNotFound
+	}
+	user, err := s.loadBy(ctx, "recover_selector", selector)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// LoadByConfirmSelector implements authboss.ConfirmingServerStorer.
+func (s *UserStorer) LoadByConfirmSelector(ctx context.Context, selector string) (authboss.ConfirmableUser, error) {
+	if selector == "" {
+		return nil, authboss.ErrUserNotFound
+	}
+	user, err := s.loadBy(ctx, "confirm_selector", selector)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// loadBy loads the user whose column equals value from the primary, since
+// authboss usually reads back what it has just saved.
+func (s *UserStorer) loadBy(ctx context.Context, column, value string) (*config.ApolloUser, error) {
+	user, err := QueryOne[config.ApolloUser](ReadYourWrites(ctx), s.manager, "SELECT "+userColumns+" FROM users WHERE "+column+" = $1;", value)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, authboss.ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func apolloUser(user authboss.User) (*config.ApolloUser, error) {
+	u, ok := user.(*config.ApolloUser)
+	if !ok {
+		return nil, fmt.Errorf("unexpected user type %T", user)
+	}
+	return u, nil
+}
@@ -34,3 +34,10 @@ func errorHandler(c *gin.Context, statusCode int, message string, config config.
 	// Set the status code and render the template
 	templateManager.Render(c, config.Path+"/error.html", pongo2.Context{"status": statusCode, "message": message}, nil)
 }
+
+// RenderError renders the error page with the given status code and aborts
+// the request, e.g. for a 403 from an authorization middleware.
+func RenderError(c *gin.Context, statusCode int, message string) {
+	templateManager.RenderStatus(c, statusCode, config.GetConfig().Templates.Path+"/error.html", pongo2.Context{"status": statusCode, "message": message}, nil)
+	c.Abort()
+}
@@ -0,0 +1,47 @@
+package error
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// checkTimeout bounds the database check done by each probe.
+const checkTimeout = 2 * time.Second
+
+// RegisterHealthRoutes serves /healthz and /readyz. /healthz answers 200 as
+// long as the process is up, /readyz answers 503 while the database can't be
+// reached. Both include the database status as JSON, for the orchestrator.
+// They need no authentication, so the status carries no error details.
+func RegisterHealthRoutes(router *gin.Engine) {
+	router.GET("/healthz", func(c *gin.Context) {
+		status, _ := checkDatabase(c)
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "database": status})
+	})
+
+	router.GET("/readyz", func(c *gin.Context) {
+		status, err := checkDatabase(c)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "database": status})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready", "database": status})
+	})
+}
+
+func checkDatabase(c *gin.Context) (dbmanager.HealthStatus, error) {
+	ctx, cancel := context.WithTimeout(dbmanager.RequestContext(c), checkTimeout)
+	defer cancel()
+
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		logrus.Errorf("Database health check failed: %v", err)
+		return dbmanager.HealthStatus{Error: dbmanager.HealthUnreachable}, err
+	}
+
+	return dbManager.HealthCheck(ctx)
+}
@@ -0,0 +1,21 @@
+package error
+
+import (
+	"net/http"
+
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// RegisterMetricsRoutes serves the database query metrics on /metrics in the
+// Prometheus text format.
+func RegisterMetricsRoutes(router *gin.Engine) {
+	router.GET("/metrics", func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := dbmanager.WriteMetrics(c.Writer); err != nil {
+			logrus.Error("Failed to write metrics: ", err)
+		}
+	})
+}
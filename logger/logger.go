@@ -53,4 +53,12 @@ func SetupLogger(cfg config.LogConfig) {
 
 	multiWriter := io.MultiWriter(logWriters...)
 	logrus.SetOutput(multiWriter)
+
+	// Scrub secrets before anything reaches the rotated log files.
+	RegisterSecret(config.GetConfig().Database.Password.Reveal())
+	for _, key := range config.GetConfig().Session.Keys {
+		RegisterSecret(key.Authentication.Reveal())
+		RegisterSecret(key.Encryption.Reveal())
+	}
+	logrus.AddHook(RedactionHook{})
 }
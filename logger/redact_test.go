@@ -0,0 +1,31 @@
+package logger
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	RegisterSecret("s3cr3t-db-pass")
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "host=db user=apollo password=hunter2 sslmode=disable", want: "host=db user=apollo password=**** sslmode=disable"},
+		{in: "GET /reset-password?token=abc.def&x=1", want: "GET /reset-password?token=****&x=1"},
+		{in: `login {"email":"ana@example.com","password":"hunter2"}`, want: `login {"email":"ana@example.com","password":"****"}`},
+		{in: "Authorization: Bearer abc.def", want: "Authorization: Bearer ****"},
+		{in: "Cookie: session=abc123; theme=dark", want: "Cookie: session=****; theme=dark"},
+		{in: "dial postgres://apollo:hunter2@db:5432/apollo", want: "dial postgres://apollo:****@db:5432/apollo"},
+		{in: "refresh_token: abc123", want: "refresh_token: ****"},
+		{in: "connecting with s3cr3t-db-pass", want: "connecting with ****"},
+		// Messages merely naming a secret are kept.
+		{in: "Error checking password reset token: invalid or expired password reset token", want: "Error checking password reset token: invalid or expired password reset token"},
+		{in: "Error resetting password: error updating password: connection refused", want: "Error resetting password: error updating password: connection refused"},
+		{in: "WebAuthn login failed: invalid token: expired", want: "WebAuthn login failed: invalid token: expired"},
+	}
+
+	for _, tt := range tests {
+		if got := Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
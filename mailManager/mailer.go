@@ -0,0 +1,164 @@
+// Package mailManager sends the emails of the application through a
+// pluggable Mailer.
+package mailManager
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Message is an email with a plain text body and an optional HTML one.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var (
+	mailer   Mailer
+	mailerMu sync.RWMutex
+)
+
+// SetMailer replaces the mailer returned by GetMailer, e.g. with one talking
+// to an SMTP relay.
+func SetMailer(m Mailer) {
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+
+	mailer = m
+}
+
+// GetMailer returns the mailer set with SetMailer, or a FileMailer writing to
+// the configured MailConfig.Dir.
+func GetMailer() Mailer {
+	mailerMu.RLock()
+	defer mailerMu.RUnlock()
+
+	if mailer != nil {
+		return mailer
+	}
+	return FileMailer{Dir: config.GetConfig().Mail.Dir}
+}
+
+// Send delivers msg with GetMailer, filling in the configured sender when
+// msg has none.
+func Send(ctx context.Context, msg Message) error {
+	if msg.From == "" {
+		msg.From = config.GetConfig().Mail.From
+	}
+	return GetMailer().Send(ctx, msg)
+}
+
+// FileMailer stands in for an SMTP server during development: every message
+// is written to Dir as an .eml file that mail clients can open.
+type FileMailer struct {
+	Dir string
+}
+
+// Send implements Mailer.
+func (m FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	dir := m.Dir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "apollo-mail")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("error creating mail directory: %v", err)
+	}
+
+	body, err := Format(msg)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("error naming mail file: %v", err)
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix)))
+
+	// Messages may carry reset links, so keep them private to the process user.
+	if err := os.WriteFile(name, body, 0o600); err != nil {
+		return fmt.Errorf("error writing mail file: %v", err)
+	}
+
+	logrus.Infof("Mail '%s' to %d recipient(s) written to %s", msg.Subject, len(msg.To), name)
+	return nil
+}
+
+// Format renders msg as an RFC 5322 message, multipart/alternative when it
+// has an HTML body.
+func Format(msg Message) ([]byte, error) {
+	if len(msg.To) == 0 {
+		return nil, fmt.Errorf("mail has no recipients")
+	}
+	for _, address := range append([]string{msg.From}, msg.To...) {
+		if address == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(address); err != nil {
+			return nil, fmt.Errorf("invalid mail address %q: %v", address, err)
+		}
+	}
+
+	var b bytes.Buffer
+	if msg.From != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", msg.From)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		writePart(&b, "text/plain", msg.Text)
+		return b.Bytes(), nil
+	}
+
+	boundary := make([]byte, 12)
+	if _, err := rand.Read(boundary); err != nil {
+		return nil, fmt.Errorf("error generating mail boundary: %v", err)
+	}
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%x\r\n\r\n", boundary)
+	fmt.Fprintf(&b, "--%x\r\n", boundary)
+	writePart(&b, "text/plain", msg.Text)
+	fmt.Fprintf(&b, "\r\n--%x\r\n", boundary)
+	writePart(&b, "text/html", msg.HTML)
+	fmt.Fprintf(&b, "\r\n--%x--\r\n", boundary)
+
+	return b.Bytes(), nil
+}
+
+// writePart writes the headers and quoted-printable body of one part.
+func writePart(b *bytes.Buffer, contentType, body string) {
+	fmt.Fprintf(b, "Content-Type: %s; charset=utf-8\r\n", contentType)
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	w := quotedprintable.NewWriter(b)
+	w.Write([]byte(body))
+	w.Close()
+}
@@ -1,19 +1,32 @@
 package models
 
-import "time"
+import (
+	"database/sql"
+	"time"
+)
 
 type MedicalRecord struct {
-	Description string `json:"description"`
-	CreatedDate int64  `json:"createDate"`
-	Date        int64  `json:"date"`
-	EntityName  string `json:"entityName"`
-	RecordType  string `json:"type"`
+	Description string `json:"description" db:"description"`
+	CreatedDate int64  `json:"createDate" db:"created_date"`
+	Date        int64  `json:"date" db:"date"`
+	EntityName  string `json:"entityName" db:"entity_name"`
+	RecordType  string `json:"type" db:"record_type"`
 }
 
+// Values of AccessControlRecord.AccessStatus. Only active grants give access.
+const (
+	AccessStatusPending = "pending"
+	AccessStatusActive  = "active"
+	AccessStatusRevoked = "revoked"
+)
+
 type AccessControlRecord struct {
-	PersonnelName      string    // The name of the medical personnel who has access
-	StartDate          time.Time // The original timestamp
-	StartDateFormatted string    // The formatted date as a string
-	AccessStatus       string    // The access status
-	AccessID           string    // The access ID
+	PersonnelName      string       `db:"personnel_name"` // The name of the medical personnel who has access
+	PersonnelID        int          `db:"personnel_id"`   // The user ID of the medical personnel
+	NumeroUtente       string       `db:"numero_utente"`  // The patient who granted the access
+	StartDate          time.Time    `db:"start_date"`     // The original timestamp
+	StartDateFormatted string       `db:"-"`              // The formatted date as a string
+	ExpiryDate         sql.NullTime `db:"expiry_date"`    // When the access ends, if ever
+	AccessStatus       string       `db:"access_status"`  // The access status
+	AccessID           string       `db:"access_id"`      // The access ID
 }
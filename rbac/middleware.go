@@ -0,0 +1,59 @@
+package rbac
+
+import (
+	"net/http"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	apperror "github.com/ApolloMedTech/Middleware/error"
+	"github.com/ApolloMedTech/Middleware/sessionmanager"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// userContextKey is where RequirePermission leaves the user for handlers.
+const userContextKey = "rbacUser"
+
+// InitRBAC loads the policy from the config. Call it once at startup, so an
+// invalid policy stops the application instead of denying every request.
+func InitRBAC() error {
+	p, err := LoadPolicy(config.GetConfig().RBAC)
+	if err != nil {
+		return err
+	}
+
+	SetPolicy(p)
+	return nil
+}
+
+// RequirePermission lets the request through only when the user logged in
+// with store has a role with permission. Otherwise it renders the error page
+// with 401 when nobody is logged in and 403 when the role lacks the
+// permission.
+func RequirePermission(store *sessionmanager.MySessionStore, permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := store.LoadUser(c.Writer, c.Request)
+		if err != nil {
+			apperror.RenderError(c, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+
+		if !Can(user, permission) {
+			logrus.Warnf("Denied %s to user %d with role %q on %s", permission, user.ID, user.UserType, c.Request.URL.Path)
+			apperror.RenderError(c, http.StatusForbidden, "You don't have permission to access this page")
+			return
+		}
+
+		c.Set(userContextKey, user)
+		c.Next()
+	}
+}
+
+// CurrentUser returns the user checked by RequirePermission for this request.
+func CurrentUser(c *gin.Context) (*config.ApolloUser, bool) {
+	user, ok := c.Get(userContextKey)
+	if !ok {
+		return nil, false
+	}
+	apolloUser, ok := user.(*config.ApolloUser)
+	return apolloUser, ok
+}
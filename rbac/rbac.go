@@ -0,0 +1,181 @@
+// Package rbac grants permissions to users through the role named by their
+// ApolloUser.UserType.
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Built-in roles, matching the values of ApolloUser.UserType.
+const (
+	RolePatient = "patient"
+	RoleNurse   = "nurse"
+	RoleDoctor  = "doctor"
+	RoleAdmin   = "admin"
+)
+
+// Permissions used by the built-in policy. Applications may define more in
+// their own policy.
+const (
+	PermissionRecordsRead   = "records:read"
+	PermissionRecordsWrite  = "records:write"
+	PermissionOwnRecordRead = "records:read_own"
+	PermissionAccessManage  = "access:manage"
+	PermissionUsersManage   = "users:manage"
+)
+
+// Wildcard grants every permission, or every permission of a resource as a
+// suffix, e.g. "records:*".
+const Wildcard = "*"
+
+// defaultRoles is the policy used when the config doesn't define one.
+var defaultRoles = map[string]config.RoleConfig{
+	RolePatient: {Permissions: []string{PermissionOwnRecordRead, PermissionAccessManage}},
+	RoleNurse:   {Permissions: []string{PermissionRecordsRead}},
+	RoleDoctor:  {Inherits: []string{RoleNurse}, Permissions: []string{PermissionRecordsWrite}},
+	RoleAdmin:   {Permissions: []string{Wildcard}},
+}
+
+// Policy maps every role to its permissions, inherited ones included.
+type Policy struct {
+	roles map[string]map[string]bool
+}
+
+// NewPolicy resolves the inheritance of roles. It fails on unknown inherited
+// roles and on cycles.
+func NewPolicy(roles map[string]config.RoleConfig) (*Policy, error) {
+	p := &Policy{roles: make(map[string]map[string]bool, len(roles))}
+
+	var resolve func(role string, path []string) (map[string]bool, error)
+	resolve = func(role string, path []string) (map[string]bool, error) {
+		if permissions, ok := p.roles[role]; ok {
+			return permissions, nil
+		}
+		for _, seen := range path {
+			if seen == role {
+				return nil, fmt.Errorf("role inheritance cycle: %s -> %s", strings.Join(path, " -> "), role)
+			}
+		}
+
+		cfg, ok := roles[role]
+		if !ok {
+			return nil, fmt.Errorf("role %q inherits unknown role %q", path[len(path)-1], role)
+		}
+
+		permissions := make(map[string]bool)
+		for _, parent := range cfg.Inherits {
+			inherited, err := resolve(parent, append(path, role))
+			if err != nil {
+				return nil, err
+			}
+			for permission := range inherited {
+				permissions[permission] = true
+			}
+		}
+		for _, permission := range cfg.Permissions {
+			permissions[permission] = true
+		}
+
+		p.roles[role] = permissions
+		return permissions, nil
+	}
+
+	// Sorted so errors are reproducible.
+	names := make([]string, 0, len(roles))
+	for role := range roles {
+		names = append(names, role)
+	}
+	sort.Strings(names)
+
+	for _, role := range names {
+		if _, err := resolve(role, nil); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Can reports whether role has permission, directly or through a wildcard.
+func (p *Policy) Can(role, permission string) bool {
+	permissions, ok := p.roles[role]
+	if !ok {
+		return false
+	}
+	if permissions[permission] || permissions[Wildcard] {
+		return true
+	}
+	if resource, _, found := strings.Cut(permission, ":"); found {
+		return permissions[resource+":"+Wildcard]
+	}
+	return false
+}
+
+// Permissions returns the sorted permissions of role.
+func (p *Policy) Permissions(role string) []string {
+	permissions := make([]string, 0, len(p.roles[role]))
+	for permission := range p.roles[role] {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+var (
+	policy   *Policy
+	policyMu sync.RWMutex
+)
+
+// LoadPolicy builds the policy of cfg: its inline roles, else the roles of
+// its policy file, else the built-in ones.
+func LoadPolicy(cfg config.RBACConfig) (*Policy, error) {
+	roles := cfg.Roles
+	if len(roles) == 0 && cfg.PolicyPath != "" {
+		var err error
+		if roles, err = config.LoadRBACPolicy(cfg.PolicyPath); err != nil {
+			return nil, err
+		}
+	}
+	if len(roles) == 0 {
+		roles = defaultRoles
+	}
+	return NewPolicy(roles)
+}
+
+// SetPolicy replaces the policy used by Can and RequirePermission.
+func SetPolicy(p *Policy) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+
+	policy = p
+}
+
+// GetPolicy returns the current policy, loading it from the config on first
+// use. A policy that fails to load denies everything, and isn't loaded again.
+func GetPolicy() *Policy {
+	policyMu.RLock()
+	p := policy
+	policyMu.RUnlock()
+	if p != nil {
+		return p
+	}
+
+	p, err := LoadPolicy(config.GetConfig().RBAC)
+	if err != nil {
+		// Deny everything rather than fall back to a policy nobody chose.
+		logrus.Errorf("Error loading RBAC policy, denying every permission: %v", err)
+		p = &Policy{}
+	}
+	SetPolicy(p)
+	return p
+}
+
+// Can reports whether user's role has permission under the current policy.
+func Can(user *config.ApolloUser, permission string) bool {
+	return user != nil && GetPolicy().Can(user.UserType, permission)
+}
@@ -0,0 +1,167 @@
+package rbac
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ApolloMedTech/Middleware/config"
+)
+
+func TestNewPolicyInheritance(t *testing.T) {
+	policy, err := NewPolicy(map[string]config.RoleConfig{
+		"nurse":     {Permissions: []string{"records:read"}},
+		"doctor":    {Inherits: []string{"nurse"}, Permissions: []string{"records:write"}},
+		"chief":     {Inherits: []string{"doctor", "registrar"}, Permissions: []string{"staff:manage"}},
+		"registrar": {Inherits: []string{"nurse"}, Permissions: []string{"patients:write"}},
+		"archivist": {Permissions: []string{"records:*"}},
+		"admin":     {Permissions: []string{Wildcard}},
+		"visitor":   {},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+
+	tests := []struct {
+		role       string
+		permission string
+		want       bool
+	}{
+		{role: "nurse", permission: "records:read", want: true},
+		{role: "nurse", permission: "records:write"},
+		{role: "doctor", permission: "records:read", want: true},
+		{role: "doctor", permission: "records:write", want: true},
+		{role: "doctor", permission: "patients:write"},
+		{role: "chief", permission: "records:read", want: true},
+		{role: "chief", permission: "records:write", want: true},
+		{role: "chief", permission: "patients:write", want: true},
+		{role: "chief", permission: "staff:manage", want: true},
+		{role: "registrar", permission: "records:write"},
+		{role: "archivist", permission: "records:delete", want: true},
+		{role: "archivist", permission: "patients:read"},
+		{role: "admin", permission: "anything:at_all", want: true},
+		{role: "visitor", permission: "records:read"},
+		{role: "unknown", permission: "records:read"},
+		{role: "", permission: "records:read"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.role+" "+tt.permission, func(t *testing.T) {
+			if got := policy.Can(tt.role, tt.permission); got != tt.want {
+				t.Errorf("Can(%q, %q) = %v, want %v", tt.role, tt.permission, got, tt.want)
+			}
+		})
+	}
+
+	want := []string{"patients:write", "records:read", "records:write", "staff:manage"}
+	if got := policy.Permissions("chief"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Permissions(chief) = %v, want %v", got, want)
+	}
+}
+
+func TestNewPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		roles   map[string]config.RoleConfig
+		wantErr string
+	}{
+		{
+			name:    "role inheriting itself",
+			roles:   map[string]config.RoleConfig{"a": {Inherits: []string{"a"}}},
+			wantErr: "role inheritance cycle: a -> a",
+		},
+		{
+			name: "two role cycle",
+			roles: map[string]config.RoleConfig{
+				"a": {Inherits: []string{"b"}},
+				"b": {Inherits: []string{"a"}},
+			},
+			wantErr: "role inheritance cycle: a -> b -> a",
+		},
+		{
+			name: "cycle below a valid role",
+			roles: map[string]config.RoleConfig{
+				"a": {Permissions: []string{"x:read"}},
+				"b": {Inherits: []string{"c"}},
+				"c": {Inherits: []string{"d"}},
+				"d": {Inherits: []string{"b"}},
+			},
+			wantErr: "role inheritance cycle: b -> c -> d -> b",
+		},
+		{
+			name:    "unknown inherited role",
+			roles:   map[string]config.RoleConfig{"doctor": {Inherits: []string{"nurse"}}},
+			wantErr: `role "doctor" inherits unknown role "nurse"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewPolicy(tt.roles)
+			if err == nil {
+				t.Fatalf("NewPolicy = %v, want an error", policy)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewPolicy error = %q, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiamondInheritanceIsNotACycle(t *testing.T) {
+	_, err := NewPolicy(map[string]config.RoleConfig{
+		"base":  {Permissions: []string{"x:read"}},
+		"left":  {Inherits: []string{"base"}},
+		"right": {Inherits: []string{"base"}},
+		"top":   {Inherits: []string{"left", "right"}},
+	})
+	if err != nil {
+		t.Errorf("NewPolicy: %v", err)
+	}
+}
+
+func TestDefaultRoles(t *testing.T) {
+	policy, err := LoadPolicy(config.RBACConfig{})
+	if err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+
+	tests := []struct {
+		role       string
+		permission string
+		want       bool
+	}{
+		{role: RolePatient, permission: PermissionOwnRecordRead, want: true},
+		{role: RolePatient, permission: PermissionRecordsRead},
+		{role: RoleNurse, permission: PermissionRecordsRead, want: true},
+		{role: RoleDoctor, permission: PermissionRecordsRead, want: true},
+		{role: RoleDoctor, permission: PermissionRecordsWrite, want: true},
+		{role: RoleDoctor, permission: PermissionUsersManage},
+		{role: RoleAdmin, permission: PermissionUsersManage, want: true},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Can(tt.role, tt.permission); got != tt.want {
+			t.Errorf("Can(%q, %q) = %v, want %v", tt.role, tt.permission, got, tt.want)
+		}
+	}
+}
+
+func TestGetPolicyKeepsFailedLoad(t *testing.T) {
+	cfg := &config.GetConfig().RBAC
+	previous := *cfg
+	*cfg = config.RBACConfig{PolicyPath: t.TempDir() + "/missing.yaml"}
+	SetPolicy(nil)
+	t.Cleanup(func() {
+		*cfg = previous
+		SetPolicy(nil)
+	})
+
+	first := GetPolicy()
+	if first.Can(RoleAdmin, PermissionUsersManage) {
+		t.Error("policy that failed to load allows admin users:manage, want everything denied")
+	}
+	if second := GetPolicy(); second != first {
+		t.Error("GetPolicy loaded the failed policy again, want the deny-all policy kept")
+	}
+}
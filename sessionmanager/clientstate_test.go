@@ -0,0 +1,209 @@
+package sessionmanager
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	authboss "github.com/volatiletech/authboss/v3"
+)
+
+// testLogger discards authboss's logs.
+type testLogger struct{}
+
+func (testLogger) Info(string)  {}
+func (testLogger) Error(string) {}
+
+// clientStateBrowser sends requests through authboss's client state
+// middleware, keeping the cookies between them like a browser.
+type clientStateBrowser struct {
+	t   *testing.T
+	ab  *authboss.Authboss
+	jar *cookiejar.Jar
+}
+
+func newClientStateBrowser(t *testing.T, store *MySessionStore) *clientStateBrowser {
+	ab := authboss.New()
+	ab.Config.Storage.SessionState = store
+	ab.Config.Core.Logger = testLogger{}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &clientStateBrowser{t: t, ab: ab, jar: jar}
+}
+
+// do sends a request whose handler reads the session values of keys and then
+// runs write, and returns the values read.
+func (b *clientStateBrowser) do(keys []string, write func(w http.ResponseWriter)) map[string]string {
+	read := map[string]string{}
+	handler := b.ab.LoadClientStateMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, key := range keys {
+			if value, ok := authboss.GetSession(r, key); ok {
+				read[key] = value
+			}
+		}
+		if write != nil {
+			write(w)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, testURL.String(), nil)
+	for _, cookie := range b.jar.Cookies(testURL) {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		b.t.Fatalf("request answered %d", w.Code)
+	}
+
+	b.jar.SetCookies(testURL, w.Result().Cookies())
+	return read
+}
+
+func TestSessionClientStateRoundTrip(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func(t *testing.T) *MySessionStore
+	}{
+		{
+			name: "cookie",
+			store: func(t *testing.T) *MySessionStore {
+				return NewMySessionStoreWithKeys(testKeyPairs...)
+			},
+		},
+		{
+			name: "database",
+			store: func(t *testing.T) *MySessionStore {
+				table := useSessionTable(t)
+				t.Cleanup(func() {
+					// The values were kept in the rows, not in the cookie,
+					// and each DelAll ended the session it was in: the
+					// whitelist one moved "keep" to a second session.
+					if len(table.rows) != 2 {
+						t.Errorf("session table has %d rows, want 2", len(table.rows))
+					}
+					for id, row := range table.rows {
+						if len(row.data) == 0 || row.active {
+							t.Errorf("session %s has no data or is still active", id)
+						}
+					}
+				})
+				return &MySessionStore{store: NewDBStore(testKeyPairs...), name: dbSessionName}
+			},
+		},
+	}
+
+	keys := []string{"a", "b", "keep", "other"}
+	steps := []struct {
+		name  string
+		want  map[string]string
+		write func(w http.ResponseWriter)
+	}{
+		{
+			name: "put",
+			want: map[string]string{},
+			write: func(w http.ResponseWriter) {
+				authboss.PutSession(w, "a", "1")
+				authboss.PutSession(w, "b", "2")
+				authboss.PutSession(w, "keep", "3")
+				authboss.PutSession(w, "other", "4")
+			},
+		},
+		{
+			name:  "del",
+			want:  map[string]string{"a": "1", "b": "2", "keep": "3", "other": "4"},
+			write: func(w http.ResponseWriter) { authboss.DelSession(w, "a") },
+		},
+		{
+			name:  "del all but the whitelist",
+			want:  map[string]string{"b": "2", "keep": "3", "other": "4"},
+			write: func(w http.ResponseWriter) { authboss.DelAllSession(w, []string{"keep", "missing"}) },
+		},
+		{
+			name: "read only",
+			want: map[string]string{"keep": "3"},
+		},
+		{
+			name:  "del all",
+			want:  map[string]string{"keep": "3"},
+			write: func(w http.ResponseWriter) { authboss.DelAllSession(w, nil) },
+		},
+		{
+			name: "empty",
+			want: map[string]string{},
+		},
+	}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			browser := newClientStateBrowser(t, tt.store(t))
+
+			for _, step := range steps {
+				if got := browser.do(keys, step.write); !reflect.DeepEqual(got, step.want) {
+					t.Fatalf("%s: read %v, want %v", step.name, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionClientStateInvalidCookie(t *testing.T) {
+	// oldCookie returns a valid cookie made with keys since removed.
+	oldCookie := func(t *testing.T) string {
+		old := newClientStateBrowser(t, NewMySessionStoreWithKeys(bytes.Repeat([]byte("o"), 32), nil))
+		old.do(nil, func(w http.ResponseWriter) { authboss.PutSession(w, "a", "1") })
+		return old.jar.Cookies(testURL)[0].Value
+	}
+	garbage := func(*testing.T) string { return "garbage" }
+
+	tests := []struct {
+		name   string
+		store  func(t *testing.T) *MySessionStore
+		cookie string
+		value  func(t *testing.T) string
+	}{
+		{
+			name:   "cookie",
+			store:  func(t *testing.T) *MySessionStore { return NewMySessionStoreWithKeys(testKeyPairs...) },
+			cookie: clientStateName,
+			value:  garbage,
+		},
+		{
+			name:   "cookie signed with a removed key",
+			store:  func(t *testing.T) *MySessionStore { return NewMySessionStoreWithKeys(testKeyPairs...) },
+			cookie: clientStateName,
+			value:  oldCookie,
+		},
+		{
+			name: "database",
+			store: func(t *testing.T) *MySessionStore {
+				useSessionTable(t)
+				return &MySessionStore{store: NewDBStore(testKeyPairs...), name: dbSessionName}
+			},
+			cookie: dbSessionName,
+			value:  garbage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			browser := newClientStateBrowser(t, tt.store(t))
+			browser.jar.SetCookies(testURL, []*http.Cookie{{Name: tt.cookie, Value: tt.value(t)}})
+
+			if got := browser.do([]string{"a"}, func(w http.ResponseWriter) { authboss.PutSession(w, "b", "2") }); len(got) != 0 {
+				t.Errorf("invalid cookie read as %v, want an empty state", got)
+			}
+			// The state written over the invalid cookie is read back.
+			if got := browser.do([]string{"a", "b"}, nil); !reflect.DeepEqual(got, map[string]string{"b": "2"}) {
+				t.Errorf("read %v after replacing the invalid cookie, want b=2", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package sessionmanager
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+	"github.com/google/uuid"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/sirupsen/logrus"
+)
+
+// touchSession extends a session used now, up to its absolute expiration.
+const touchSession = "expiration_date = LEAST(now() + idle_timeout, absolute_expiration), last_seen = now()"
+
+// idleTimeoutKey holds, in seconds, the idle timeout of a session regenerated
+// at login until the session is stored.
+const idleTimeoutKey = "IdleTimeout"
+
+// ErrSessionInvalid is returned when saving a session that was invalidated or
+// expired while the request was being handled.
+var ErrSessionInvalid = errors.New("session is no longer valid")
+
+// DBStore is a sessions.Store keeping the session values in the session
+// table. The cookie only holds the signed session ID, so invalidating the row
+// logs the user out on their next request.
+type DBStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	// Policy is the lifetime of sessions until someone logs in.
+	Policy SessionPolicy
+	// serializer encodes the session values for the data column.
+	serializer securecookie.GobEncoder
+}
+
+// NewDBStore creates a DBStore signing its cookies with the given
+// authentication and encryption key pairs, as taken by
+// sessions.NewCookieStore, and with the lifetimes of SessionConfig.
+func NewDBStore(keyPairs ...[]byte) *DBStore {
+	cfg := config.GetConfig().Session
+	policy := PolicyFor(cfg, "", false)
+
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(maxAge(longestLifetime(cfg)))
+		}
+	}
+
+	return &DBStore{
+		Codecs:  codecs,
+		Options: CookieOptions(cfg, maxAge(policy.AbsoluteLifetime)),
+		Policy:  policy,
+	}
+}
+
+// Get returns the session named name, loading it once per request.
+func (s *DBStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the request's cookie. It returns a new
+// session when there is no cookie or when its row is inactive or expired.
+// Loading a session extends it by its idle timeout, up to its absolute
+// lifetime, which the cookie's MaxAge is set to.
+func (s *DBStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.Codecs...); err != nil {
+		// A cookie signed with a removed key, or forged: start over.
+		return session, nil
+	}
+
+	stored, err := s.touch(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return session, nil
+		}
+		return session, err
+	}
+
+	if err := s.serializer.Deserialize(stored.Data, &session.Values); err != nil {
+		return session, fmt.Errorf("failed to decode session values: %v", err)
+	}
+	session.ID = id
+	session.IsNew = false
+	session.Options.MaxAge = maxAge(time.Until(stored.AbsoluteExpiration))
+	return session, nil
+}
+
+// storedSession is the part of a session row New needs.
+type storedSession struct {
+	Data               []byte    `db:"data"`
+	AbsoluteExpiration time.Time `db:"absolute_expiration"`
+}
+
+// touch returns an active session and slides its expiration date, in one
+// statement so a concurrent invalidation can't be missed.
+func (s *DBStore) touch(ctx context.Context, id string) (storedSession, error) {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return storedSession{}, err
+	}
+
+	return dbmanager.QueryOne[storedSession](dbmanager.ReadYourWrites(ctx), dbManager, `UPDATE session SET `+touchSession+`
+		WHERE session_id = $1 AND active AND expiration_date > now()
+		RETURNING data, absolute_expiration;`, id)
+}
+
+// Save stores the session values and sets the cookie. A new session lasts
+// for its MaxAge at most. A negative MaxAge invalidates the session and
+// deletes the cookie.
+func (s *DBStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	ctx := r.Context()
+
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" && !session.IsNew {
+			if err := s.invalidate(ctx, session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	// Set by Regenerate for the new session only.
+	idleTimeout := s.Policy.IdleTimeout
+	if seconds, ok := session.Values[idleTimeoutKey].(int64); ok {
+		idleTimeout = time.Duration(seconds) * time.Second
+		delete(session.Values, idleTimeoutKey)
+	}
+
+	data, err := s.serializer.Serialize(session.Values)
+	if err != nil {
+		return fmt.Errorf("failed to encode session values: %v", err)
+	}
+
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return err
+	}
+
+	// The user of the session, once logged in.
+	var userID sql.NullInt64
+	if id, ok := session.Values[UserIDKey].(int); ok {
+		userID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+
+	if session.IsNew {
+		if session.ID == "" {
+			session.ID = uuid.New().String()
+		}
+
+		absolute := time.Duration(session.Options.MaxAge) * time.Second
+		if idleTimeout > absolute {
+			idleTimeout = absolute
+		}
+
+		now := time.Now()
+		_, err = dbManager.InsertReturningUUID(ctx, `INSERT INTO session (session_id, user_id, start_date, expiration_date, absolute_expiration, idle_timeout, data, ip, user_agent)
+			VALUES ($1, $2, $3, $4, $5, $6 * interval '1 second', $7, $8, $9) RETURNING session_id;`,
+			session.ID, userID, now, now.Add(idleTimeout), now.Add(absolute), int64(idleTimeout/time.Second), data, clientIP(r), r.UserAgent())
+		if err != nil {
+			return err
+		}
+		session.IsNew = false
+	} else {
+		rows, err := dbManager.UpdateContext(ctx, "UPDATE session SET data = $2, user_id = COALESCE($3, user_id) WHERE session_id = $1 AND active AND expiration_date > now();",
+			session.ID, data, userID)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrSessionInvalid
+		}
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return fmt.Errorf("failed to encode session cookie: %v", err)
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Regenerate gives session a new ID and no values, invalidating the old one,
+// so an ID set before logging in can't be used afterwards. The new session is
+// stored on the next Save, lasting as long as policy allows.
+func (s *DBStore) Regenerate(ctx context.Context, session *sessions.Session, policy SessionPolicy) error {
+	if session.ID != "" && !session.IsNew {
+		if err := s.invalidate(ctx, session.ID); err != nil {
+			return err
+		}
+	}
+
+	session.ID = uuid.New().String()
+	session.IsNew = true
+	session.Values = map[interface{}]interface{}{idleTimeoutKey: int64(policy.IdleTimeout / time.Second)}
+	session.Options.MaxAge = maxAge(policy.AbsoluteLifetime)
+	return nil
+}
+
+func (s *DBStore) invalidate(ctx context.Context, id string) error {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return err
+	}
+
+	if _, err := dbManager.UpdateContext(ctx, "UPDATE session SET active = FALSE WHERE session_id = $1;", id); err != nil {
+		logrus.Errorf("Error invalidating session %s: %v", id, err)
+		return err
+	}
+	return nil
+}
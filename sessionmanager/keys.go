@@ -0,0 +1,66 @@
+package sessionmanager
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultSessionKey is the signing key the session cookies used before the
+// keys became configurable, see config.DefaultSessionKey.
+const DefaultSessionKey = config.DefaultSessionKey
+
+// SessionKeyPairs decodes the configured keys into the authentication and
+// encryption key pairs taken by sessions.NewCookieStore, newest first, see
+// config.SessionConfig.KeyPairs. It warns when the default key is still in
+// use, and falls back to it when no keys are configured.
+func SessionKeyPairs(cfg config.SessionConfig) ([][]byte, error) {
+	keyPairs, err := cfg.KeyPairs()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Keys) == 0 {
+		logrus.Warn("No session keys configured, session cookies are signed with the public default key. Configure session.keys or SESSION_KEYS.")
+		return keyPairs, nil
+	}
+	for i, key := range cfg.Keys {
+		if key.Authentication.Reveal() == DefaultSessionKey {
+			if i == 0 {
+				logrus.Warn("Session cookies are signed with the public default key. Add a new key pair first in session.keys.")
+			} else {
+				logrus.Infof("Session key pair %d is the default key, remove it once the cookies signed with it have expired", i+1)
+			}
+		}
+	}
+	if keyPairs[1] == nil {
+		logrus.Warn("Session cookies are signed but not encrypted, set an encryption key in the first session key pair")
+	}
+	return keyPairs, nil
+}
+
+// configuredKeyPairs returns the key pairs of the loaded config. LoadConfig
+// already refused invalid keys; should they be set some other way, no key
+// pair is returned, so the stores fail to save rather than sign with a key
+// nobody configured.
+func configuredKeyPairs() [][]byte {
+	keyPairs, err := SessionKeyPairs(config.GetConfig().Session)
+	if err != nil {
+		logrus.Errorf("Error loading session keys, sessions can't be saved: %v", err)
+		return nil
+	}
+	return keyPairs
+}
+
+// GenerateSessionKey returns a new base64 encoded random key of size bytes,
+// for a session key pair.
+func GenerateSessionKey(size int) (string, error) {
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate session key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
@@ -0,0 +1,56 @@
+package sessionmanager
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/ApolloMedTech/Middleware/config"
+)
+
+func TestGeneratedSessionKeysAreAccepted(t *testing.T) {
+	tests := []struct {
+		name           string
+		authentication int
+		encryption     int
+	}{
+		{name: "defaults of cmd/sessionkeys", authentication: 64, encryption: 32},
+		{name: "AES-128", authentication: 32, encryption: 16},
+		{name: "signed only", authentication: 32},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pair config.SessionKeyConfig
+			authentication, err := GenerateSessionKey(tt.authentication)
+			if err != nil {
+				t.Fatalf("GenerateSessionKey: %v", err)
+			}
+			pair.Authentication = config.Redacted(authentication)
+			if tt.encryption > 0 {
+				encryption, err := GenerateSessionKey(tt.encryption)
+				if err != nil {
+					t.Fatalf("GenerateSessionKey: %v", err)
+				}
+				pair.Encryption = config.Redacted(encryption)
+			}
+
+			keyPairs, err := SessionKeyPairs(config.SessionConfig{Keys: []config.SessionKeyConfig{pair}})
+			if err != nil {
+				t.Fatalf("SessionKeyPairs: %v", err)
+			}
+			if len(keyPairs) != 2 || len(keyPairs[0]) != tt.authentication || len(keyPairs[1]) != tt.encryption {
+				t.Errorf("SessionKeyPairs returned keys of %d and %d bytes, want %d and %d", len(keyPairs[0]), len(keyPairs[1]), tt.authentication, tt.encryption)
+			}
+			if decoded, _ := base64.StdEncoding.DecodeString(authentication); string(decoded) != string(keyPairs[0]) {
+				t.Error("SessionKeyPairs didn't decode the generated authentication key")
+			}
+		})
+	}
+}
+
+func TestSessionKeyPairsInvalid(t *testing.T) {
+	_, err := SessionKeyPairs(config.SessionConfig{Keys: []config.SessionKeyConfig{{Authentication: "c2hvcnQ="}}})
+	if err == nil {
+		t.Error("SessionKeyPairs accepted a 5 byte authentication key")
+	}
+}
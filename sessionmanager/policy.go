@@ -0,0 +1,95 @@
+package sessionmanager
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	defaultIdleTimeout        = 2 * time.Hour
+	defaultAbsoluteLifetime   = 24 * time.Hour
+	defaultRememberMeLifetime = 30 * 24 * time.Hour
+)
+
+// SessionPolicy is how long a session lasts.
+type SessionPolicy struct {
+	// IdleTimeout ends the session when it isn't used for this long.
+	IdleTimeout time.Duration
+	// AbsoluteLifetime ends the session this long after it started.
+	AbsoluteLifetime time.Duration
+}
+
+// PolicyFor returns the policy of cfg for sessions of userType, with its
+// overrides applied. With rememberMe the remember me lifetime replaces both
+// limits, unless remember me is disabled for userType.
+func PolicyFor(cfg config.SessionConfig, userType string, rememberMe bool) SessionPolicy {
+	settings := cfg.SessionPolicyConfig
+	if override, ok := cfg.UserTypes[userType]; ok {
+		if override.IdleTimeout != 0 {
+			settings.IdleTimeout = override.IdleTimeout
+		}
+		if override.AbsoluteLifetime != 0 {
+			settings.AbsoluteLifetime = override.AbsoluteLifetime
+		}
+		if override.RememberMeLifetime != 0 {
+			settings.RememberMeLifetime = override.RememberMeLifetime
+		}
+	}
+
+	if settings.IdleTimeout <= 0 {
+		settings.IdleTimeout = defaultIdleTimeout
+	}
+	if settings.AbsoluteLifetime <= 0 {
+		settings.AbsoluteLifetime = defaultAbsoluteLifetime
+	}
+	if settings.RememberMeLifetime == 0 {
+		settings.RememberMeLifetime = defaultRememberMeLifetime
+	}
+
+	if rememberMe && settings.RememberMeLifetime > 0 {
+		return SessionPolicy{IdleTimeout: settings.RememberMeLifetime, AbsoluteLifetime: settings.RememberMeLifetime}
+	}
+	if settings.IdleTimeout > settings.AbsoluteLifetime {
+		settings.IdleTimeout = settings.AbsoluteLifetime
+	}
+	return SessionPolicy{IdleTimeout: settings.IdleTimeout, AbsoluteLifetime: settings.AbsoluteLifetime}
+}
+
+// longestLifetime returns the longest absolute lifetime a session can have
+// under cfg, beyond which no session cookie is accepted.
+func longestLifetime(cfg config.SessionConfig) time.Duration {
+	longest := PolicyFor(cfg, "", true).AbsoluteLifetime
+	if lifetime := PolicyFor(cfg, "", false).AbsoluteLifetime; lifetime > longest {
+		longest = lifetime
+	}
+	for userType := range cfg.UserTypes {
+		for _, rememberMe := range []bool{false, true} {
+			if lifetime := PolicyFor(cfg, userType, rememberMe).AbsoluteLifetime; lifetime > longest {
+				longest = lifetime
+			}
+		}
+	}
+	return longest
+}
+
+// maxAge returns d in the seconds used by cookies.
+func maxAge(d time.Duration) int {
+	return int(d / time.Second)
+}
+
+// CookieOptions returns the options of the session cookies, lasting for
+// maxAge seconds: sent over HTTPS only unless cfg.InsecureCookies is set,
+// hidden from scripts, and not sent along cross-site requests other than
+// top-level navigations.
+func CookieOptions(cfg config.SessionConfig, maxAge int) *sessions.Options {
+	return &sessions.Options{
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   !cfg.InsecureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
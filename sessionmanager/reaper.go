@@ -0,0 +1,136 @@
+package sessionmanager
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/config"
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultReapInterval  = 10 * time.Minute
+	defaultReapBatchSize = 1000
+)
+
+// Reaper deletes expired and invalidated sessions in the background.
+type Reaper struct {
+	interval  time.Duration
+	batchSize int
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	stop    chan struct{}
+	done    chan struct{}
+	running bool
+}
+
+// NewReaper creates a Reaper with the settings of cfg.
+func NewReaper(cfg config.SessionConfig) *Reaper {
+	r := &Reaper{interval: cfg.ReapInterval, batchSize: cfg.ReapBatchSize}
+	if r.interval <= 0 {
+		r.interval = defaultReapInterval
+	}
+	if r.batchSize <= 0 {
+		r.batchSize = defaultReapBatchSize
+	}
+	return r
+}
+
+// Start runs the reaper in a goroutine until Stop is called. Calling it
+// again while running does nothing.
+func (r *Reaper) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running {
+		return
+	}
+	r.running = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+
+	go r.run(ctx, r.stop, r.done)
+}
+
+func (r *Reaper) run(ctx context.Context, stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Reap(ctx, stop); err != nil && ctx.Err() == nil {
+			logrus.Errorf("Error reaping sessions: %v", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reap deletes expired and invalidated sessions batch by batch, until none
+// are left or stop is closed, and returns how many it deleted.
+func (r *Reaper) Reap(ctx context.Context, stop <-chan struct{}) (int64, error) {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for {
+		deleted, err := dbManager.DeleteContext(ctx, `DELETE FROM session WHERE session_id IN (
+			SELECT session_id FROM session WHERE active = FALSE OR expiration_date <= now() LIMIT $1);`, r.batchSize)
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+
+		if deleted < int64(r.batchSize) {
+			break
+		}
+		select {
+		case <-stop:
+			return total, nil
+		default:
+		}
+	}
+
+	if total > 0 {
+		logrus.Infof("Deleted %d expired or invalidated session(s)", total)
+	}
+	return total, nil
+}
+
+// Stop stops the reaper, letting the current batch finish. When ctx is done
+// first the batch is cancelled and ctx's error returned.
+func (r *Reaper) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.running {
+		r.mu.Unlock()
+		return nil
+	}
+	r.running = false
+	close(r.stop)
+	cancel, done := r.cancel, r.done
+	r.mu.Unlock()
+
+	defer cancel()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		<-done
+		return ctx.Err()
+	}
+}
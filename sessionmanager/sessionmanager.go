@@ -1,12 +1,16 @@
 package sessionmanager
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/ApolloMedTech/Middleware/config"
 	"github.com/ApolloMedTech/Middleware/dbmanager"
 	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
@@ -14,38 +18,110 @@ import (
 	authboss "github.com/volatiletech/authboss/v3"
 )
 
+// Keys of the values kept in the session.
+const (
+	// SessionKey holds the session token from CreateSession.
+	SessionKey = "Session"
+	// UserKey holds the logged in config.ApolloUser, as saved with SaveObject.
+	UserKey = "User"
+	// SecondFactorKey holds the session token for which the user completed a
+	// second factor, so it doesn't carry over to a later session.
+	SecondFactorKey = "SecondFactor"
+	// UserIDKey holds the ID of the logged in user, for the DBStore to link
+	// the session row to the user.
+	UserIDKey = "UserID"
+)
+
+// ErrSessionExpired is returned by LoadUser when the request had a session
+// that timed out or was invalidated, so the user has to log in again.
+var ErrSessionExpired = errors.New("session expired, please log in again")
+
+// clientStateName is the session holding the authboss session state.
+const clientStateName = "Authboss"
+
+// dbSessionName is the cookie of the sessions kept by the DBStore.
+const dbSessionName = "ApolloSession"
+
 // MySessionStore is a custom session store that implements the authboss.SessionState interface.
 type MySessionStore struct {
 	store sessions.Store
+	// name is the single session all values are saved in. When empty every
+	// key has a session, and cookie, of its own.
+	name string
 }
 
 // SessionState is an authboss.ClientState implementation that
 // holds the request's session values for the duration of the request.
 type SessionState struct {
 	session *sessions.Session
+	// request is the one the state was read from, which the store needs to
+	// save the session again in WriteState.
+	request *http.Request
 }
 
-// NewMySessionStore creates a new instance of MySessionStore.
+// NewMySessionStore creates a new instance of MySessionStore, with the
+// session keys from the config.
 func NewMySessionStore() *MySessionStore {
+	return NewMySessionStoreWithKeys(configuredKeyPairs()...)
+}
+
+// NewMySessionStoreWithKeys creates a MySessionStore whose cookies use the
+// given authentication and encryption key pairs, as taken by
+// sessions.NewCookieStore. The first pair makes new cookies, the others only
+// read existing ones.
+func NewMySessionStoreWithKeys(keyPairs ...[]byte) *MySessionStore {
+	cfg := config.GetConfig().Session
+	store := sessions.NewCookieStore(keyPairs...)
+	store.Options = CookieOptions(cfg, 0)
+	// No cookie is accepted past the longest session lifetime.
+	store.MaxAge(maxAge(longestLifetime(cfg)))
 	return &MySessionStore{
-		store: sessions.NewCookieStore([]byte("6ba7b810-9dad-11d1-80b4-00c04fd430c8")),
+		store: store,
 	}
 }
 
+// NewDBSessionStore creates a MySessionStore keeping the session values in
+// the database, see DBStore, with the session keys from the config.
+func NewDBSessionStore() *MySessionStore {
+	return &MySessionStore{
+		store: NewDBStore(configuredKeyPairs()...),
+		name:  dbSessionName,
+	}
+}
+
+// sessionName returns the session key is saved in.
+func (m *MySessionStore) sessionName(key string) string {
+	if m.name != "" {
+		return m.name
+	}
+	return key
+}
+
 func (m *MySessionStore) CreateSession(userID int) (uuid.UUID, error) {
+	return m.CreateSessionContext(context.Background(), userID)
+}
+
+// CreateSessionContext is like CreateSession but stops querying the database
+// once ctx is done.
+func (m *MySessionStore) CreateSessionContext(ctx context.Context, userID int) (uuid.UUID, error) {
+	return m.createSession(ctx, userID, PolicyFor(config.GetConfig().Session, "", false), "", "")
+}
+
+// createSession creates a session row lasting as long as policy allows and
+// recording the client it was made from.
+func (m *MySessionStore) createSession(ctx context.Context, userID int, policy SessionPolicy, ip, userAgent string) (uuid.UUID, error) {
 
 	// Use ConnectDB to establish a database connection
 	dbManager, err := dbmanager.NewDBManager()
 	if err != nil {
 		return uuid.Nil, err
 	}
-	defer dbManager.DB.Close()
-
-	token := uuid.New()
 
-	// Prepare SQL query for user insertion
-	_, err = dbManager.DB.Exec("INSERT INTO session (session_id, user_id, start_date, expiration_date) VALUES ($1, $2, $3, $4);", token, userID, time.Now(),
-		time.Now().AddDate(0, 0, 1)) // fica por agora com um dia de sessão.
+	// Prepare SQL query for session insertion
+	now := time.Now()
+	token, err := dbManager.InsertReturningUUID(ctx, `INSERT INTO session (session_id, user_id, start_date, expiration_date, absolute_expiration, idle_timeout, ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6 * interval '1 second', $7, $8) RETURNING session_id;`,
+		uuid.New(), userID, now, now.Add(policy.IdleTimeout), now.Add(policy.AbsoluteLifetime), int64(policy.IdleTimeout/time.Second), ip, userAgent)
 	if err != nil {
 		return uuid.Nil, err
 	}
@@ -55,16 +131,21 @@ func (m *MySessionStore) CreateSession(userID int) (uuid.UUID, error) {
 }
 
 func (m *MySessionStore) InvalidateSession(sessionID uuid.UUID) error {
+	return m.InvalidateSessionContext(context.Background(), sessionID)
+}
+
+// InvalidateSessionContext is like InvalidateSession but stops querying the
+// database once ctx is done.
+func (m *MySessionStore) InvalidateSessionContext(ctx context.Context, sessionID uuid.UUID) error {
 
 	// Use ConnectDB to establish a database connection
 	dbManager, err := dbmanager.NewDBManager()
 	if err != nil {
 		return err
 	}
-	defer dbManager.DB.Close()
 
 	// Prepare SQL query for user insertion
-	_, err = dbManager.DB.Exec("UPDATE session SET active = 0 where session_id = $1;", sessionID) // fica por agora com um dia de sessão.
+	_, err = dbManager.UpdateContext(ctx, "UPDATE session SET active = FALSE where session_id = $1;", sessionID)
 	if err != nil {
 		return err
 	}
@@ -75,7 +156,7 @@ func (m *MySessionStore) InvalidateSession(sessionID uuid.UUID) error {
 
 func (m *MySessionStore) IsAuthenticated(w http.ResponseWriter, r *http.Request) bool {
 
-	ssk, err := m.Load(w, r, "Session")
+	ssk, err := m.Load(w, r, SessionKey)
 
 	if err != nil {
 		logrus.Errorf("Error making request to microservice: %v", err)
@@ -87,37 +168,87 @@ func (m *MySessionStore) IsAuthenticated(w http.ResponseWriter, r *http.Request)
 		return false
 	}
 
-	return true
+	return m.validSession(r, ssk)
+}
+
+// validSession reports whether the session token is still active and not
+// expired. The DBStore already checked it when loading the session.
+func (m *MySessionStore) validSession(r *http.Request, token string) bool {
+	_, ok := m.sessionUser(r, token)
+	return ok
+}
+
+// sessionUser returns the ID of the user the session token was created for,
+// when it is still active and not expired. The DBStore keeps it in the session
+// it already checked when loading it.
+func (m *MySessionStore) sessionUser(r *http.Request, token string) (int, bool) {
+	if _, ok := m.store.(*DBStore); ok {
+		session, err := m.store.Get(r, m.name)
+		if err != nil {
+			return 0, false
+		}
+		userID, ok := session.Values[UserIDKey].(int)
+		return userID, ok
+	}
+
+	sessionID, err := uuid.Parse(token)
+	if err != nil {
+		return 0, false
+	}
+
+	userID, err := touchSessionRow(r.Context(), sessionID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logrus.Errorf("Error validating session %s: %v", sessionID, err)
+		}
+		return 0, false
+	}
+	return int(userID.Int64), userID.Valid
 }
 
-// func (m *MySessionStore) IsSessionStilValid(sessionID uuid.UUID) (bool, error) {
-// 	// Use ConnectDB to establish a database connection
-// 	dbManager, err := dbmanager.NewDBManager()
-// 	if err != nil {
-// 		return false, err
-// 	}
-// 	defer dbManager.DB.Close()
+// ValidateSessionContext reports whether the session is active and not
+// expired, and extends it by its idle timeout when it is.
+func (m *MySessionStore) ValidateSessionContext(ctx context.Context, sessionID uuid.UUID) (bool, error) {
+	if _, err := touchSessionRow(ctx, sessionID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
 
-// 	// Prepare SQL query for user insertion
-// 	_, err = dbManager.DB.Exec("select session_id from session where expiration_date <=  CURRENT_TIMESTAMP() and active = 1")
-// 	if err != nil {
-// 		return false, err
-// 	}
+// touchSessionRow extends an active session row by its idle timeout and
+// returns its user. It returns sql.ErrNoRows when the session is inactive or
+// expired.
+func touchSessionRow(ctx context.Context, sessionID uuid.UUID) (sql.NullInt64, error) {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
 
-// 	// If the insertion was successful, return nil indicating no error
-// 	return true, nil
-// }
+	return dbmanager.QueryOne[sql.NullInt64](dbmanager.ReadYourWrites(ctx), dbManager,
+		"UPDATE session SET "+touchSession+" WHERE session_id = $1 AND active AND expiration_date > now() RETURNING user_id;", sessionID)
+}
 
 // Save saves the session data for a given session token.
 func (m *MySessionStore) Save(w http.ResponseWriter, r *http.Request, key, value string) error {
-	session, err := m.store.Get(r, key)
+	return m.save(w, r, key, value, PolicyFor(config.GetConfig().Session, "", false))
+}
+
+// save saves value under key, in a cookie lasting as long as policy allows.
+// Sessions of the DBStore keep the lifetime they were created with.
+func (m *MySessionStore) save(w http.ResponseWriter, r *http.Request, key, value string, policy SessionPolicy) error {
+	session, err := m.store.Get(r, m.sessionName(key))
 	if err != nil {
 		return err
 	}
 
 	// Store the user ID in the session, assuming it's stored as "user_id"
 	session.Values[key] = value
-	session.Options.MaxAge = 2 * 60 * 60
+	if _, ok := m.store.(*DBStore); !ok {
+		session.Options.MaxAge = maxAge(policy.AbsoluteLifetime)
+	}
 
 	// Save the session
 	if err := session.Save(r, w); err != nil {
@@ -127,8 +258,21 @@ func (m *MySessionStore) Save(w http.ResponseWriter, r *http.Request, key, value
 	return nil
 }
 
+// DestroySession ends the session: it invalidates its row, so the token can't
+// be used again even from a copy of the cookie, and deletes the cookie.
 func (m *MySessionStore) DestroySession(w http.ResponseWriter, r *http.Request) error {
-	session, err := m.store.Get(r, "Session")
+	// The DBStore invalidates its own row when saving the expired session.
+	if _, ok := m.store.(*DBStore); !ok {
+		if token, err := m.Load(w, r, SessionKey); err == nil {
+			if sessionID, err := uuid.Parse(token); err == nil {
+				if err := m.InvalidateSessionContext(r.Context(), sessionID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	session, err := m.store.Get(r, m.sessionName(SessionKey))
 	if err != nil {
 		return err
 	}
@@ -147,13 +291,11 @@ func (m *MySessionStore) SaveObject(w http.ResponseWriter, r *http.Request, key
 		return fmt.Errorf("failed to marshal value to JSON: %v", err)
 	}
 
-	m.Save(w, r, key, string(jsonValue))
-
-	return nil
+	return m.Save(w, r, key, string(jsonValue))
 }
 
 func (m *MySessionStore) Load(w http.ResponseWriter, r *http.Request, key string) (string, error) {
-	session, err := m.store.Get(r, key)
+	session, err := m.store.Get(r, m.sessionName(key))
 	if err != nil {
 		return "", err
 	}
@@ -167,44 +309,161 @@ func (m *MySessionStore) Load(w http.ResponseWriter, r *http.Request, key string
 	return userID, nil
 }
 
-// ReadState implements authboss.ClientStateReadWriter.
-func (m *MySessionStore) ReadState(r *http.Request) (authboss.ClientState, error) {
-	// Retrieve the session from the store using the request
-	var state authboss.ClientState
+// StartSession logs user in: it creates a session row and saves its token
+// and the user in the session cookies.
+func (m *MySessionStore) StartSession(w http.ResponseWriter, r *http.Request, user *config.ApolloUser) error {
+	return m.StartSessionRememberMe(w, r, user, false)
+}
 
-	session, err := m.store.Get(r, "my_session_name")
+// StartSessionRememberMe is like StartSession, for users who asked to stay
+// logged in when rememberMe is set. The session lasts as long as the
+// SessionConfig policy of the user's type allows.
+func (m *MySessionStore) StartSessionRememberMe(w http.ResponseWriter, r *http.Request, user *config.ApolloUser, rememberMe bool) error {
+	policy := PolicyFor(config.GetConfig().Session, user.UserType, rememberMe)
+
+	if db, ok := m.store.(*DBStore); ok {
+		return m.startDBSession(w, r, db, user, policy)
+	}
+
+	token, err := m.createSession(r.Context(), user.ID, policy, clientIP(r), r.UserAgent())
 	if err != nil {
-		// Return an empty state if the session is not found (no error for missing session)
-		if err == http.ErrNoCookie {
-			return state, nil
-		}
-		return nil, fmt.Errorf("failed to read client state from session: %v", err)
+		return err
 	}
 
-	// Extract the client state from the session
-	state, ok := session.Values["clientState"].(authboss.ClientState)
-	if !ok {
-		// Return an empty state if the client state is not found in the session
-		return state, nil
+	jsonUser, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to marshal value to JSON: %v", err)
 	}
 
-	return state, nil
+	if err := m.save(w, r, SessionKey, token.String(), policy); err != nil {
+		return err
+	}
+	return m.save(w, r, UserKey, string(jsonUser), policy)
 }
 
-// Get a key from the session
-func (s SessionState) Get(key string) (string, bool) {
-	str, ok := s.session.Values[key]
+// startDBSession logs user in with a new session of the DBStore, replacing
+// the one the request had.
+func (m *MySessionStore) startDBSession(w http.ResponseWriter, r *http.Request, db *DBStore, user *config.ApolloUser, policy SessionPolicy) error {
+	session, err := db.Get(r, m.name)
+	if err != nil {
+		logrus.Warnf("Error loading session, starting a new one: %v", err)
+	}
+	if err := db.Regenerate(r.Context(), session, policy); err != nil {
+		return err
+	}
+
+	jsonUser, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to marshal value to JSON: %v", err)
+	}
+
+	session.Values[SessionKey] = session.ID
+	session.Values[UserIDKey] = user.ID
+	session.Values[UserKey] = string(jsonUser)
+	return session.Save(r, w)
+}
+
+// LoadUser returns the user saved under UserKey, as long as their session is
+// still valid and was created for them.
+func (m *MySessionStore) LoadUser(w http.ResponseWriter, r *http.Request) (*config.ApolloUser, error) {
+	token, err := m.Load(w, r, SessionKey)
+	if err != nil {
+		if m.sessionLost(r) {
+			return nil, ErrSessionExpired
+		}
+		return nil, err
+	}
+	userID, ok := m.sessionUser(r, token)
 	if !ok {
-		return "", false
+		return nil, ErrSessionExpired
+	}
+
+	value, err := m.Load(w, r, UserKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var user config.ApolloUser
+	if err := json.Unmarshal([]byte(value), &user); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user from session: %v", err)
 	}
-	value := str.(string)
 
+	// Cookie sessions keep the user in a cookie of its own, which must belong
+	// to the session's user rather than come from another session.
+	if user.ID != userID {
+		logrus.Warnf("Session user cookie holds user %d, the session belongs to user %d", user.ID, userID)
+		return nil, ErrSessionExpired
+	}
+	return &user, nil
+}
+
+// sessionLost reports whether the request had a DBStore session that is no
+// longer valid, since the store then starts a new one.
+func (m *MySessionStore) sessionLost(r *http.Request) bool {
+	if _, ok := m.store.(*DBStore); !ok {
+		return false
+	}
+	if _, err := r.Cookie(m.name); err != nil {
+		return false
+	}
+	session, err := m.store.Get(r, m.name)
+	return err == nil && session.IsNew
+}
+
+// CompleteSecondFactor records that the user of the current session has
+// passed a second factor check.
+func (m *MySessionStore) CompleteSecondFactor(w http.ResponseWriter, r *http.Request) error {
+	token, err := m.Load(w, r, SessionKey)
+	if err != nil {
+		return err
+	}
+	return m.Save(w, r, SecondFactorKey, token)
+}
+
+// SecondFactorCompleted reports whether CompleteSecondFactor was called
+// during the current session.
+func (m *MySessionStore) SecondFactorCompleted(w http.ResponseWriter, r *http.Request) bool {
+	token, err := m.Load(w, r, SessionKey)
+	if err != nil || token == "" {
+		return false
+	}
+	completed, err := m.Load(w, r, SecondFactorKey)
+	return err == nil && completed == token
+}
+
+// ReadState implements authboss.ClientStateReadWriter. A session that can't
+// be decoded, e.g. one signed with a removed key, reads as empty.
+func (m *MySessionStore) ReadState(r *http.Request) (authboss.ClientState, error) {
+	session, err := m.store.Get(r, m.sessionName(clientStateName))
+	if err != nil {
+		if session == nil {
+			return nil, fmt.Errorf("failed to read client state from session: %v", err)
+		}
+		logrus.Warnf("Ignoring invalid client state session: %v", err)
+	}
+
+	return &SessionState{session: session, request: r}, nil
+}
+
+// Get a key from the session
+func (s *SessionState) Get(key string) (string, bool) {
+	value, ok := s.session.Values[key].(string)
 	return value, ok
 }
 
-func (s MySessionStore) WriteState(w http.ResponseWriter, state authboss.ClientState, ev []authboss.ClientStateEvent) error {
-	ses := state.(*SessionState)
+// WriteState implements authboss.ClientStateReadWriter. It applies the
+// events to the state read by ReadState and saves the session.
+func (m *MySessionStore) WriteState(w http.ResponseWriter, state authboss.ClientState, ev []authboss.ClientStateEvent) error {
+	if len(ev) == 0 {
+		return nil
+	}
+
+	ses, ok := state.(*SessionState)
+	if !ok || ses == nil {
+		return fmt.Errorf("client state %T was not read by MySessionStore", state)
+	}
 
+	delAll := false
 	for _, ev := range ev {
 		switch ev.Kind {
 		case authboss.ClientStateEventPut:
@@ -212,19 +471,42 @@ func (s MySessionStore) WriteState(w http.ResponseWriter, state authboss.ClientS
 		case authboss.ClientStateEventDel:
 			delete(ses.session.Values, ev.Key)
 		case authboss.ClientStateEventDelAll:
-			if len(ev.Key) == 0 {
-				ses.session.Options.MaxAge = -1
-			} else {
-				whitelist := strings.Split(ev.Key, ",")
-				s.DeleteSessionValues(ses, whitelist)
+			// Key lists the values to keep, comma separated.
+			var whitelist []string
+			if ev.Key != "" {
+				whitelist = strings.Split(ev.Key, ",")
 			}
+			m.DeleteSessionValues(ses, whitelist)
+			delAll = true
 		}
 	}
 
-	return s.store.Save(nil, w, ses.session)
+	db, ok := m.store.(*DBStore)
+	if !ok {
+		ses.session.Options.MaxAge = maxAge(PolicyFor(config.GetConfig().Session, "", false).AbsoluteLifetime)
+		return ses.session.Save(ses.request, w)
+	}
+
+	// Deleting every value ends the session, as DestroySession does: its row
+	// is invalidated, and the values kept move to a new session.
+	if delAll {
+		kept := ses.session.Values
+		if len(kept) == 0 {
+			ses.session.Options.MaxAge = -1
+		} else {
+			if err := db.Regenerate(ses.request.Context(), ses.session, db.Policy); err != nil {
+				return err
+			}
+			for key, value := range kept {
+				ses.session.Values[key] = value
+			}
+		}
+	}
+	return ses.session.Save(ses.request, w)
 }
 
-func (MySessionStore) DeleteSessionValues(ses *SessionState, whitelist []string) {
+// DeleteSessionValues deletes the values of ses but those in whitelist.
+func (*MySessionStore) DeleteSessionValues(ses *SessionState, whitelist []string) {
 	for key := range ses.session.Values {
 		if k, ok := key.(string); ok && !contains(whitelist, k) {
 			delete(ses.session.Values, key)
@@ -0,0 +1,156 @@
+package sessionmanager
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ApolloMedTech/Middleware/config"
+)
+
+// testKeyPairs sign and encrypt the cookies of the tests.
+var testKeyPairs = [][]byte{bytes.Repeat([]byte("a"), 32), bytes.Repeat([]byte("e"), 32)}
+
+var testURL, _ = url.Parse("https://apollo.example/")
+
+// cookieJar returns an empty jar for testURL.
+func cookieJar(t *testing.T) *cookiejar.Jar {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jar
+}
+
+// send runs handler on a request carrying the cookies of jar, and stores the
+// response cookies back in it.
+func send(jar *cookiejar.Jar, handler func(w http.ResponseWriter, r *http.Request)) {
+	r := httptest.NewRequest(http.MethodGet, testURL.String(), nil)
+	for _, cookie := range jar.Cookies(testURL) {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	jar.SetCookies(testURL, w.Result().Cookies())
+}
+
+// cookie returns the jar's cookie named name, nil when there is none.
+func cookie(jar *cookiejar.Jar, name string) *http.Cookie {
+	for _, c := range jar.Cookies(testURL) {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestCookieSessionLoadUser(t *testing.T) {
+	ana := &config.ApolloUser{ID: 7, Name: "Ana", Email: "ana@example.com"}
+	rui := &config.ApolloUser{ID: 8, Name: "Rui", Email: "rui@example.com"}
+
+	tests := []struct {
+		name string
+		// tamper changes the cookies of Ana's session, given those of Rui's.
+		tamper  func(t *testing.T, store *MySessionStore, ana, rui *cookiejar.Jar)
+		wantErr error
+	}{
+		{
+			name: "own user cookie",
+		},
+		{
+			name: "user cookie of another session",
+			tamper: func(t *testing.T, store *MySessionStore, ana, rui *cookiejar.Jar) {
+				ana.SetCookies(testURL, []*http.Cookie{cookie(rui, UserKey)})
+			},
+			wantErr: ErrSessionExpired,
+		},
+		{
+			name: "copied cookies after logout",
+			tamper: func(t *testing.T, store *MySessionStore, ana, rui *cookiejar.Jar) {
+				copied := cookieJar(t)
+				copied.SetCookies(testURL, ana.Cookies(testURL))
+				send(ana, func(w http.ResponseWriter, r *http.Request) {
+					if err := store.DestroySession(w, r); err != nil {
+						t.Fatalf("DestroySession: %v", err)
+					}
+				})
+				ana.SetCookies(testURL, copied.Cookies(testURL))
+			},
+			wantErr: ErrSessionExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useSessionTable(t)
+			store := NewMySessionStoreWithKeys(testKeyPairs...)
+
+			anaJar, ruiJar := cookieJar(t), cookieJar(t)
+			for jar, user := range map[*cookiejar.Jar]*config.ApolloUser{anaJar: ana, ruiJar: rui} {
+				send(jar, func(w http.ResponseWriter, r *http.Request) {
+					if err := store.StartSession(w, r, user); err != nil {
+						t.Fatalf("StartSession: %v", err)
+					}
+				})
+			}
+			if tt.tamper != nil {
+				tt.tamper(t, store, anaJar, ruiJar)
+			}
+
+			send(anaJar, func(w http.ResponseWriter, r *http.Request) {
+				user, err := store.LoadUser(w, r)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("LoadUser error = %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantErr == nil && user.ID != ana.ID {
+					t.Errorf("LoadUser = user %d, want %d", user.ID, ana.ID)
+				}
+			})
+		})
+	}
+}
+
+func TestDestroySessionInvalidatesRow(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func() *MySessionStore
+	}{
+		{name: "cookie", store: func() *MySessionStore { return NewMySessionStoreWithKeys(testKeyPairs...) }},
+		{name: "database", store: func() *MySessionStore {
+			return &MySessionStore{store: NewDBStore(testKeyPairs...), name: dbSessionName}
+		}},
+	}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			table := useSessionTable(t)
+			store := tt.store()
+			jar := cookieJar(t)
+
+			var token string
+			send(jar, func(w http.ResponseWriter, r *http.Request) {
+				if err := store.StartSession(w, r, &config.ApolloUser{ID: 7}); err != nil {
+					t.Fatalf("StartSession: %v", err)
+				}
+			})
+			send(jar, func(w http.ResponseWriter, r *http.Request) {
+				var err error
+				if token, err = store.Load(w, r, SessionKey); err != nil {
+					t.Fatalf("Load: %v", err)
+				}
+				if err := store.DestroySession(w, r); err != nil {
+					t.Fatalf("DestroySession: %v", err)
+				}
+			})
+
+			row, ok := table.row(token)
+			if !ok || row.active {
+				t.Errorf("session row %s found %v, active %v, want an inactive row", token, ok, row.active)
+			}
+		})
+	}
+}
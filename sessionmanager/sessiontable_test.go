@@ -0,0 +1,183 @@
+package sessionmanager
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+)
+
+// sessionTable is a database/sql driver keeping the session table in memory.
+// It understands the statements of this package only, and doesn't expire
+// sessions but past their absolute expiration.
+type sessionTable struct {
+	mu   sync.Mutex
+	rows map[string]*sessionRow
+}
+
+type sessionRow struct {
+	userID   driver.Value
+	data     []byte
+	active   bool
+	absolute time.Time
+}
+
+// useSessionTable makes an empty sessionTable the shared pool of dbmanager
+// for the test.
+func useSessionTable(t *testing.T) *sessionTable {
+	table := &sessionTable{rows: make(map[string]*sessionRow)}
+	dbmanager.SetDB(sql.OpenDB(table))
+	t.Cleanup(func() { dbmanager.ClosePool() })
+	return table
+}
+
+// row returns a copy of the session's row.
+func (s *sessionTable) row(id string) (sessionRow, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.rows[id]
+	if !ok {
+		return sessionRow{}, false
+	}
+	return *row, true
+}
+
+// valid returns the row of an active and unexpired session.
+func (s *sessionTable) valid(id driver.Value) (*sessionRow, bool) {
+	row, ok := s.rows[fmt.Sprint(id)]
+	return row, ok && row.active && row.absolute.After(time.Now())
+}
+
+func (s *sessionTable) query(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO session"):
+		row := &sessionRow{userID: args[1], active: true, absolute: args[4].(time.Time)}
+		if len(args) == 9 {
+			row.data = args[6].([]byte)
+		}
+		s.rows[fmt.Sprint(args[0])] = row
+		return []string{"session_id"}, [][]driver.Value{{args[0]}}, nil
+	case strings.Contains(query, "RETURNING data, absolute_expiration"):
+		if row, ok := s.valid(args[0]); ok {
+			return []string{"data", "absolute_expiration"}, [][]driver.Value{{row.data, row.absolute}}, nil
+		}
+		return []string{"data", "absolute_expiration"}, nil, nil
+	case strings.Contains(query, "RETURNING user_id"):
+		if row, ok := s.valid(args[0]); ok {
+			return []string{"user_id"}, [][]driver.Value{{row.userID}}, nil
+		}
+		return []string{"user_id"}, nil, nil
+	}
+	return nil, nil, fmt.Errorf("unexpected query %q", query)
+}
+
+func (s *sessionTable) exec(query string, args []driver.Value) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, "UPDATE session SET data"):
+		row, ok := s.valid(args[0])
+		if !ok {
+			return 0, nil
+		}
+		row.data = args[1].([]byte)
+		if args[2] != nil {
+			row.userID = args[2]
+		}
+		return 1, nil
+	case strings.HasPrefix(query, "UPDATE session SET active = FALSE"):
+		row, ok := s.rows[fmt.Sprint(args[0])]
+		if !ok {
+			return 0, nil
+		}
+		row.active = false
+		return 1, nil
+	}
+	return 0, fmt.Errorf("unexpected statement %q", query)
+}
+
+func (s *sessionTable) Open(string) (driver.Conn, error) {
+	return sessionConn{s}, nil
+}
+
+func (s *sessionTable) Connect(context.Context) (driver.Conn, error) {
+	return sessionConn{s}, nil
+}
+
+func (s *sessionTable) Driver() driver.Driver {
+	return s
+}
+
+type sessionConn struct {
+	table *sessionTable
+}
+
+func (c sessionConn) Prepare(string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c sessionConn) Close() error {
+	return nil
+}
+
+func (c sessionConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions aren't supported")
+}
+
+func (c sessionConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rows, err := c.table.exec(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(rows), nil
+}
+
+func (c sessionConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	columns, rows, err := c.table.query(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return &tableRows{columns: columns, rows: rows}, nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	list := make([]driver.Value, len(args))
+	for i, arg := range args {
+		list[i] = arg.Value
+	}
+	return list
+}
+
+type tableRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *tableRows) Columns() []string {
+	return r.columns
+}
+
+func (r *tableRows) Close() error {
+	return nil
+}
+
+func (r *tableRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
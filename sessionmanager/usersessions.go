@@ -0,0 +1,101 @@
+package sessionmanager
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ApolloMedTech/Middleware/dbmanager"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// SessionInfo describes one of a user's active sessions.
+type SessionInfo struct {
+	ID             uuid.UUID `db:"session_id" json:"id"`
+	IP             string    `db:"ip" json:"ip"`
+	UserAgent      string    `db:"user_agent" json:"userAgent"`
+	StartDate      time.Time `db:"start_date" json:"startDate"`
+	LastSeen       time.Time `db:"last_seen" json:"lastSeen"`
+	ExpirationDate time.Time `db:"expiration_date" json:"expirationDate"`
+	// Current is set on the session of the request listing the sessions.
+	Current bool `json:"current"`
+}
+
+// clientIP returns the address r came from. Behind a proxy it is the proxy's
+// address unless r.RemoteAddr was rewritten from X-Forwarded-For.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// CurrentSessionID returns the ID of the request's session.
+func (m *MySessionStore) CurrentSessionID(w http.ResponseWriter, r *http.Request) (uuid.UUID, error) {
+	token, err := m.Load(w, r, SessionKey)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.Parse(token)
+}
+
+// ListSessions returns the active sessions of the user, most recently used
+// first, marking the one with currentID.
+func (m *MySessionStore) ListSessions(ctx context.Context, userID int, currentID uuid.UUID) ([]SessionInfo, error) {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := dbmanager.QueryAll[SessionInfo](dbmanager.ReadYourWrites(ctx), dbManager, `SELECT session_id, ip, user_agent, start_date, last_seen, expiration_date
+		FROM session
+		WHERE user_id = $1 AND active AND expiration_date > now()
+		ORDER BY last_seen DESC;`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	return sessions, nil
+}
+
+// RevokeSession invalidates one of the user's sessions. It returns
+// sql.ErrNoRows when the user has no such active session.
+func (m *MySessionStore) RevokeSession(ctx context.Context, userID int, sessionID uuid.UUID) error {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return err
+	}
+
+	rows, err := dbManager.UpdateContext(ctx, "UPDATE session SET active = FALSE WHERE user_id = $1 AND session_id = $2 AND active;", userID, sessionID)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeOtherSessions invalidates every session of the user but currentID,
+// logging them out everywhere else, and returns how many it invalidated.
+func (m *MySessionStore) RevokeOtherSessions(ctx context.Context, userID int, currentID uuid.UUID) (int64, error) {
+	dbManager, err := dbmanager.NewDBManager()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := dbManager.UpdateContext(ctx, "UPDATE session SET active = FALSE WHERE user_id = $1 AND session_id <> $2 AND active;", userID, currentID)
+	if err != nil {
+		return 0, err
+	}
+
+	logrus.Infof("Revoked %d other session(s) of user %d", rows, userID)
+	return rows, nil
+}
@@ -2,6 +2,7 @@
 package templateManager
 
 import (
+	"fmt"
 	"github.com/ApolloMedTech/Middleware/alertManager"
 	"github.com/ApolloMedTech/Middleware/localization"
 	"github.com/flosch/pongo2/v6"
@@ -12,6 +13,11 @@ import (
 
 // Render is a helper function to render a http_template with Pongo2
 func Render(c *gin.Context, templateFile string, data pongo2.Context, localizationStrings *map[string]string) {
+	RenderStatus(c, http.StatusOK, templateFile, data, localizationStrings)
+}
+
+// RenderStatus is like Render but answers with the given status code.
+func RenderStatus(c *gin.Context, status int, templateFile string, data pongo2.Context, localizationStrings *map[string]string) {
 
 	// Check if an alert is provided and add it to the context
 	alerts := alertManager.GetAlerts(c)
@@ -47,5 +53,20 @@ func Render(c *gin.Context, templateFile string, data pongo2.Context, localizati
 	}
 	alertManager.ClearAlerts(c)
 	c.Header("Content-Type", "text/html; charset=utf-8")
-	c.String(http.StatusOK, html)
+	c.String(status, html)
+}
+
+// RenderToString renders a template with Pongo2 outside of a request, e.g.
+// for the body of an email.
+func RenderToString(templateFile string, data pongo2.Context) (string, error) {
+	template, err := pongo2.FromFile(templateFile)
+	if err != nil {
+		return "", fmt.Errorf("template error: %v", err)
+	}
+
+	out, err := template.Execute(data)
+	if err != nil {
+		return "", fmt.Errorf("template execution error: %v", err)
+	}
+	return out, nil
 }